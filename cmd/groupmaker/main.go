@@ -1,21 +1,43 @@
 package main
 
 import (
-	"log"
 	"log/slog"
 	"os"
 	"strconv"
 	"time"
 
 	"agregator/group/internal/pkg/app"
+	"agregator/group/internal/pkg/logger"
 )
 
 func main() {
+	log := logger.New()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:], log))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:], log))
+	}
+
+	diff_float, distance_float, alpha_float := settings(log)
+	log.Info("Starting group maker", "diff", diff_float, "alpha", alpha_float, "distance", distance_float)
+
+	app, err := app.New(diff_float, distance_float, alpha_float, 30*time.Second, log)
+	if err != nil {
+		log.Error("Error starting group maker", "error", err)
+		os.Exit(1)
+	}
+	app.Run()
+}
+
+// settings reads DIFF, DISTANCE and ALPHA percentages
+func settings(log *slog.Logger) (float64, float64, float64) {
 	diff_str := os.Getenv("DIFF")
 	diff_int, err := strconv.Atoi(diff_str)
 
 	if err != nil {
-		log.Default().Println("Error converting DIFF to int:", err, "using default value")
+		log.Warn("Error converting DIFF to int, using default value", "error", err)
 		diff_int = 85
 	}
 	if diff_int < 0 || diff_int > 100 {
@@ -27,7 +49,7 @@ func main() {
 	alpha_str := os.Getenv("ALPHA")
 	alpha_int, err := strconv.Atoi(alpha_str)
 	if err != nil {
-		log.Default().Println("Error converting ALPHA to int:", err, "using default value")
+		log.Warn("Error converting ALPHA to int, using default value", "error", err)
 		alpha_int = 20
 	}
 	if alpha_int < 0 || alpha_int > 100 {
@@ -45,8 +67,5 @@ func main() {
 	}
 	distance_float := float64(distance_int) / float64(100)
 
-	log.Default().Printf("DIFF: %v, ALPHA: %v, DISTANCE: %v\n", diff_float, alpha_float, distance_float)
-
-	app := app.New(diff_float, distance_float, alpha_float, 30*time.Second, slog.Default())
-	app.Run()
+	return diff_float, distance_float, alpha_float
 }
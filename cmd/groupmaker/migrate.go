@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"agregator/group/internal/service/db"
+)
+
+// runMigrate handles "groupmaker migrate up|down [steps]|version" and returns exit code
+func runMigrate(args []string, log *slog.Logger) int {
+	if len(args) == 0 {
+		log.Error("Usage: groupmaker migrate up|down [steps]|version")
+		return 2
+	}
+	conn, err := db.New(2, log)
+	if err != nil {
+		log.Error("Error connecting to db", "error", err)
+		return 1
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		err = conn.MigrateUp(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				log.Error("Steps must be positive integer", "steps", args[1])
+				return 2
+			}
+		}
+		err = conn.MigrateDown(ctx, steps)
+	case "version":
+	default:
+		log.Error("Unknown migrate command", "command", args[0])
+		return 2
+	}
+	if err != nil {
+		log.Error("Migration failed", "error", err)
+		return 1
+	}
+
+	version, err := conn.SchemaVersion(ctx)
+	if err != nil {
+		log.Error("Error getting schema version", "error", err)
+		return 1
+	}
+	log.Info("Schema version", "version", version, "latest", db.LatestSchemaVersion())
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/internal/pkg/app"
+	"agregator/group/internal/service/jsonl"
+	"agregator/group/internal/service/replay"
+)
+
+// runReplay handles "groupmaker replay [flags] dump.jsonl": feeds model.Item lines
+// through the group maker and writes resulting news as JSON lines.
+// Storage, embedding and search are configured as for the service, so replay
+// either only classifies (-dry-run) or has to be allowed to change them (-write).
+// -write needs STORAGE=memory, the admin server is not started.
+func runReplay(args []string, log *slog.Logger) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	out := flags.String("out", "-", "output JSONL file, - for stdout")
+	rate := flags.Float64("rate", 0, "items per second, 0 for as fast as possible")
+	clock := flags.Bool("clock", false, "process items in PubDate order with one worker")
+	speed := flags.Float64("speed", 0, "with -clock, replay PubDate gaps this many times faster than real time, 0 for no waiting")
+	envelope := flags.Bool("envelope", false, "write event, key and headers along with the news, and cluster events")
+	workers := flags.Int("workers", 0, "news processed concurrently, defaults to WORKERS")
+	dryRun := flags.Bool("dry-run", false, "only classify items against existing clusters, write article.classified events")
+	write := flags.Bool("write", false, "create clusters in the configured storage and search index")
+	err := flags.Parse(args)
+	if err != nil || flags.NArg() != 1 || *dryRun == *write {
+		log.Error("Usage: groupmaker replay (-dry-run | -write) [-out file] [-rate n] [-clock [-speed x]] [-envelope] [-workers n] dump.jsonl")
+		return 2
+	}
+	if *write {
+		log.Warn("Replay writes clusters and index documents to the in-memory storage and the configured search index",
+			"search", os.Getenv("ELASTIC_HOST"))
+	}
+	// Порядок PubDate сохраняется только при последовательной обработке
+	if *clock && *workers == 0 {
+		*workers = 1
+	}
+
+	sink, err := jsonl.NewSink(*out, *envelope, log)
+	if err != nil {
+		log.Error("Error opening output", "error", err, "path", *out)
+		return 1
+	}
+	// Без конверта события кластеров нельзя отличить от новостей
+	var clusters interfaces.Sink
+	if *envelope && *write {
+		clusters = sink
+	}
+	source := replay.New(flags.Arg(0), replay.Options{Rate: *rate, Clock: *clock, Speed: *speed}, log)
+
+	diff, distance, alpha := settings(log)
+	// Outbox общей базы разбирают все экземпляры сервиса, поэтому -write пишет только в память
+	options := app.Options{Workers: *workers, MemoryOnly: *write}
+	replayer, err := app.NewWithTransport(source, sink, clusters, diff, distance, alpha, 30*time.Second, options, log)
+	if errors.Is(err, app.ErrSharedStorage) {
+		log.Error("Replay -write needs STORAGE=memory, use -dry-run to classify against the shared storage")
+		return 2
+	}
+	if err != nil {
+		log.Error("Error starting replay", "error", err)
+		return 1
+	}
+	if *dryRun {
+		replayer.ClassifyOnly()
+	}
+	start := time.Now()
+	replayer.Run()
+	log.Info("Replay finished", "items", source.Read(), "duration", time.Since(start))
+	return 0
+}
@@ -0,0 +1,35 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	model "agregator/group/internal/model/kafka"
+)
+
+type Classifier interface {
+	Classify(ctx context.Context, text model.News) (model.Classification, error)
+}
+
+// RegisterClassifyAPI adds POST /classify which shows how the news would be clustered
+// without saving anything
+func (s *Server) RegisterClassifyAPI(classifier Classifier) {
+	s.mux.HandleFunc("POST /classify", func(w http.ResponseWriter, r *http.Request) {
+		var text model.News
+		if err := json.NewDecoder(r.Body).Decode(&text); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid news: "+err.Error())
+			return
+		}
+		if text.Title == "" && text.Description == "" && text.FullText == "" {
+			writeError(w, http.StatusBadRequest, "title, description or full_text is required")
+			return
+		}
+		result, err := classifier.Classify(r.Context(), text)
+		if err != nil {
+			s.internalError(w, "Error classifying news", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"agregator/group/internal/service/db"
+	"agregator/group/service/vector"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+type ClusterStore interface {
+	ListClusters(ctx context.Context, filter db.ClusterFilter) ([]db.Cluster, error)
+	GetCluster(ctx context.Context, id uint64) (db.Cluster, error)
+	GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error)
+	GetMembers(ctx context.Context, id uint64, limit int) ([]db.Member, error)
+	GetAssignment(ctx context.Context, feedID uint64) (db.Assignment, error)
+}
+
+type clusterAPI struct {
+	store  ClusterStore
+	server *Server
+}
+
+// RegisterClusterAPI adds read-only endpoints for clusters and assignments
+func (s *Server) RegisterClusterAPI(store ClusterStore) {
+	api := &clusterAPI{store: store, server: s}
+	s.mux.HandleFunc("GET /clusters", api.list)
+	s.mux.HandleFunc("GET /clusters/{id}", api.get)
+	s.mux.HandleFunc("GET /news/{feed_id}/cluster", api.assignment)
+}
+
+func (api *clusterAPI) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := db.ClusterFilter{Limit: defaultLimit}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "since must be RFC3339")
+			return
+		}
+		filter.Since = &t
+	}
+	if rt := query.Get("rt"); rt != "" {
+		isRT, err := strconv.ParseBool(rt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "rt must be boolean")
+			return
+		}
+		filter.IsRT = &isRT
+	}
+	if limit := query.Get("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			writeError(w, http.StatusBadRequest, "limit must be positive integer")
+			return
+		}
+		filter.Limit = min(value, maxLimit)
+	}
+	if offset := query.Get("offset"); offset != "" {
+		value, err := strconv.Atoi(offset)
+		if err != nil || value < 0 {
+			writeError(w, http.StatusBadRequest, "offset must be non-negative integer")
+			return
+		}
+		filter.Offset = value
+	}
+
+	clusters, err := api.store.ListClusters(r.Context(), filter)
+	if err != nil {
+		api.server.internalError(w, "Error listing clusters", err)
+		return
+	}
+	response := map[string]any{
+		"items":  clusters,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	}
+	if len(clusters) == filter.Limit {
+		response["next_offset"] = filter.Offset + filter.Limit
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type member struct {
+	FeedID     int64    `json:"feed_id"`
+	Title      string   `json:"title,omitempty"`
+	Link       string   `json:"link,omitempty"`
+	Similarity *float64 `json:"similarity,omitempty"`
+	// DuplicateOf is the original of a near-duplicate
+	DuplicateOf int64 `json:"duplicate_of,omitempty"`
+}
+
+type centroidStats struct {
+	Dimensions    int     `json:"dimensions"`
+	Norm          float64 `json:"norm"`
+	Cohesion      float64 `json:"cohesion"`
+	MinSimilarity float64 `json:"min_similarity"`
+}
+
+func (api *clusterAPI) get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid cluster id")
+		return
+	}
+	cluster, err := api.store.GetCluster(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "cluster not found")
+		return
+	}
+	if err != nil {
+		api.server.internalError(w, "Error getting cluster", err)
+		return
+	}
+	rows, err := api.store.GetMembers(r.Context(), id, 0)
+	if err != nil {
+		api.server.internalError(w, "Error getting members", err)
+		return
+	}
+	centroid, err := api.store.GetCentroid(r.Context(), id)
+	if err != nil {
+		api.server.internalError(w, "Error getting centroid", err)
+		return
+	}
+
+	members := make([]member, len(rows))
+	var stats *centroidStats
+	if centroid != nil {
+		stats = &centroidStats{Dimensions: centroid.Capacity(), Norm: centroid.Module(), MinSimilarity: math.Inf(1)}
+	}
+	similarities := 0
+	for i, row := range rows {
+		members[i] = member{FeedID: row.FeedID, Title: row.Title.String, Link: row.Link.String, DuplicateOf: row.DuplicateOf.Int64}
+		if stats == nil || !row.Embedding.Valid {
+			continue
+		}
+		vec, err := vector.FromPqString(row.Embedding.String)
+		if err != nil {
+			continue
+		}
+		similarity := vec.CosDistance(centroid)
+		members[i].Similarity = &similarity
+		stats.Cohesion += similarity
+		stats.MinSimilarity = math.Min(stats.MinSimilarity, similarity)
+		similarities++
+	}
+	if stats != nil {
+		if similarities > 0 {
+			stats.Cohesion /= float64(similarities)
+		} else {
+			stats.MinSimilarity = 0
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cluster":  cluster,
+		"centroid": stats,
+		"members":  members,
+	})
+}
+
+func (api *clusterAPI) assignment(w http.ResponseWriter, r *http.Request) {
+	feedID, err := strconv.ParseUint(r.PathValue("feed_id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid feed id")
+		return
+	}
+	assignment, err := api.store.GetAssignment(r.Context(), feedID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "assignment not found")
+		return
+	}
+	if err != nil {
+		api.server.internalError(w, "Error getting assignment", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, assignment)
+}
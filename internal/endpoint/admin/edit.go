@@ -0,0 +1,186 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"agregator/group/internal/service/db"
+)
+
+type ClusterEditor interface {
+	Move(ctx context.Context, feedID, target int64, actor string) error
+	Detach(ctx context.Context, feedID int64, actor string) (int64, error)
+	Merge(ctx context.Context, source, target int64, actor string) ([]int64, error)
+	Pin(ctx context.Context, feedID int64, pinned bool, actor string) error
+}
+
+type editAPI struct {
+	editor ClusterEditor
+	server *Server
+	// tokens maps bearer tokens to editor names saved in the audit log
+	tokens map[string]string
+}
+
+// RegisterEditAPI adds endpoints for manual cluster editing. ADMIN_TOKENS lists
+// editors and their bearer tokens as "name:token" pairs separated by commas,
+// the editor is taken from the token of the request. Without tokens the API is disabled.
+func (s *Server) RegisterEditAPI(editor ClusterEditor) {
+	api := &editAPI{editor: editor, server: s, tokens: map[string]string{}}
+	for _, pair := range strings.Split(os.Getenv("ADMIN_TOKENS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || name == "" || token == "" {
+			s.logger.Warn("Skipping invalid ADMIN_TOKENS entry, expected name:token")
+			continue
+		}
+		api.tokens[token] = name
+	}
+	if len(api.tokens) == 0 {
+		s.logger.Warn("Edit API is disabled, ADMIN_TOKENS is not set")
+		return
+	}
+	s.mux.HandleFunc("POST /news/{feed_id}/move", api.move)
+	s.mux.HandleFunc("POST /news/{feed_id}/detach", api.detach)
+	s.mux.HandleFunc("POST /news/{feed_id}/pin", api.pin)
+	s.mux.HandleFunc("POST /clusters/{id}/merge", api.merge)
+}
+
+func pathID(r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue(name), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// actor returns the editor owning the bearer token of the request
+func (api *editAPI) actor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok {
+		// Сравниваем со всеми токенами за постоянное время
+		user := ""
+		for known, name := range api.tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+				user = name
+			}
+		}
+		if user != "" {
+			return user, true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, "valid bearer token is required")
+	return "", false
+}
+
+func (api *editAPI) editError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrNotClustered), errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "news or cluster not found")
+	case errors.Is(err, db.ErrFounder):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, db.ErrSameCluster):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		api.server.internalError(w, "Error editing cluster", err)
+	}
+}
+
+func (api *editAPI) move(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.actor(w, r)
+	if !ok {
+		return
+	}
+	feedID, ok := pathID(r, "feed_id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid feed id")
+		return
+	}
+	var body struct {
+		ClusterID int64 `json:"cluster_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ClusterID <= 0 {
+		writeError(w, http.StatusBadRequest, "cluster_id is required")
+		return
+	}
+	if err := api.editor.Move(r.Context(), feedID, body.ClusterID, user); err != nil {
+		api.editError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"feed_id": feedID, "cluster_id": body.ClusterID})
+}
+
+func (api *editAPI) detach(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.actor(w, r)
+	if !ok {
+		return
+	}
+	feedID, ok := pathID(r, "feed_id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid feed id")
+		return
+	}
+	created, err := api.editor.Detach(r.Context(), feedID, user)
+	if err != nil {
+		api.editError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"feed_id": feedID, "cluster_id": created})
+}
+
+func (api *editAPI) pin(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.actor(w, r)
+	if !ok {
+		return
+	}
+	feedID, ok := pathID(r, "feed_id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid feed id")
+		return
+	}
+	body := struct {
+		Pinned bool `json:"pinned"`
+	}{Pinned: true}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+	}
+	if err := api.editor.Pin(r.Context(), feedID, body.Pinned, user); err != nil {
+		api.editError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"feed_id": feedID, "pinned": body.Pinned})
+}
+
+func (api *editAPI) merge(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.actor(w, r)
+	if !ok {
+		return
+	}
+	source, ok := pathID(r, "id")
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid cluster id")
+		return
+	}
+	var body struct {
+		Into int64 `json:"into"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Into <= 0 {
+		writeError(w, http.StatusBadRequest, "into is required")
+		return
+	}
+	moved, err := api.editor.Merge(r.Context(), source, body.Into, user)
+	if err != nil {
+		api.editError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cluster_id": body.Into, "merged": source, "moved": moved})
+}
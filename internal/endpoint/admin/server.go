@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/metrics"
+)
+
+const checkTimeout = 3 * time.Second
+
+// Check returns nil if the dependency is usable
+type Check func(ctx context.Context) error
+
+type Server struct {
+	addr   string
+	mux    *http.ServeMux
+	checks map[string]Check
+	mu     sync.RWMutex
+	logger interfaces.Logger
+}
+
+func New(addr string, logger interfaces.Logger) *Server {
+	server := &Server{
+		addr:   addr,
+		mux:    http.NewServeMux(),
+		checks: make(map[string]Check),
+		logger: logger,
+	}
+	server.mux.HandleFunc("GET /healthz", server.healthz)
+	server.mux.HandleFunc("GET /readyz", server.readyz)
+	server.mux.Handle("GET /metrics", metrics.Handler())
+	return server
+}
+
+func (s *Server) AddCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks[name] = check
+}
+
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+func (s *Server) Run() error {
+	s.logger.Info("Starting admin server", "addr", s.addr)
+	return http.ListenAndServe(s.addr, s.mux)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	checks := make(map[string]Check, len(s.checks))
+	for name, check := range s.checks {
+		checks[name] = check
+	}
+	s.mu.RUnlock()
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, len(checks))
+	for name, check := range checks {
+		go func() {
+			results <- result{name: name, err: check(ctx)}
+		}()
+	}
+
+	status := http.StatusOK
+	response := map[string]string{}
+	for range checks {
+		res := <-results
+		if res.err != nil {
+			status = http.StatusServiceUnavailable
+			response[res.name] = res.err.Error()
+			s.logger.Warn("Readiness check failed", "check", res.name, "error", res.err)
+			continue
+		}
+		response[res.name] = "ok"
+	}
+	writeJSON(w, status, map[string]any{"status": http.StatusText(status), "checks": response})
+}
+
+func (s *Server) internalError(w http.ResponseWriter, msg string, err error) {
+	s.logger.Error(msg, "error", err)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
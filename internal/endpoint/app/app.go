@@ -1,18 +1,33 @@
 package app
 
 import (
+	"agregator/group/internal/endpoint/admin"
 	"agregator/group/internal/interfaces"
 	model "agregator/group/internal/model/kafka"
 	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/duplicate"
 	"agregator/group/internal/service/elastic"
 	"agregator/group/internal/service/embedding"
-	"agregator/group/internal/service/kafka"
+	"agregator/group/internal/service/lexical"
+	"agregator/group/internal/service/metrics"
 	"agregator/group/internal/service/newgroupmaker"
+	"agregator/group/internal/service/outbox"
+	"agregator/group/internal/service/pipeline"
+	"agregator/group/internal/service/representative"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/summary"
+	"agregator/group/internal/service/tracing"
+	"agregator/group/internal/service/transport"
 	"context"
-	"math"
+	"fmt"
+	"hash/fnv"
 	"os"
+	"strconv"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RTChekcer interface {
@@ -21,8 +36,10 @@ type RTChekcer interface {
 
 type App struct {
 	embedding     *embedding.Service
-	kafka         *kafka.Kafka
-	db            *db.DB
+	source        interfaces.Source
+	sink          interfaces.Sink
+	clusters      interfaces.Sink
+	db            db.Store
 	maker         *newgroupmaker.Group
 	elastic       *elastic.Elastic
 	timeOut       time.Duration
@@ -31,88 +48,214 @@ type App struct {
 	workerLimiter chan struct{}
 	wg            sync.WaitGroup
 	checker       RTChekcer
+	scorer        *lexical.Scorer
+	duplicates    *duplicate.Index
+	pipeline      *pipeline.Pipeline
+	dryRun        *pipeline.Pipeline
+	relay         *outbox.Relay
+	configVersion string
+	// classifyOnly заменяет обработку на dry-run классификацию
+	classifyOnly bool
 }
 
-func New(diff, maxDistance, alpha float64, timeOut time.Duration, checker RTChekcer, logger interfaces.Logger) *App {
-
-	db, err := db.New(30)
+func New(db db.Store, source interfaces.Source, sink, clusters interfaces.Sink, diff, maxDistance, alpha float64, timeOut time.Duration, checker RTChekcer, logger interfaces.Logger) (*App, error) {
+	err := db.CheckSchema(context.Background())
 	if err != nil {
-		logger.Error("Error creating db", "error", err)
-		return nil
+		return nil, err
+	}
+	// Все исходящие события проверяются по схеме и получают заголовок версии
+	sink = schema.NewSink(transport.Batch(sink), logger)
+	if clusters != nil {
+		clusters = schema.NewClusterSink(transport.Batch(clusters), logger)
 	}
-	elastic := elastic.New()
+	elastic := elastic.New(logger)
 	embedding := embedding.New(logger)
-	kafka := kafka.New([]string{os.Getenv("KAFKA_HOST")}, "aggregator-group", "group-maker", "elastic-text-read")
-	maker := newgroupmaker.New(db, kafka, elastic)
+	selector, err := representative.New(logger)
+	if err != nil {
+		return nil, err
+	}
+	summarizer, err := summary.New(logger)
+	if err != nil {
+		return nil, err
+	}
+	maker := newgroupmaker.New(db, clusters, selector, summarizer, elastic, logger)
 
-	return &App{
+	app := &App{
 		timeOut:       timeOut,
 		logger:        logger,
 		embedding:     embedding,
 		db:            db,
-		kafka:         kafka,
+		source:        source,
+		sink:          sink,
+		clusters:      clusters,
 		elastic:       elastic,
 		maker:         maker,
+		relay:         outbox.New(db, sink, clusters, elastic, logger),
 		mu:            sync.Mutex{},
-		workerLimiter: make(chan struct{}, 30),
+		workerLimiter: make(chan struct{}, workers(logger)),
 		wg:            sync.WaitGroup{},
 		checker:       checker,
+		scorer:        lexical.New(logger),
+		duplicates:    duplicate.New(logger),
+		configVersion: configVersion(),
+	}
+	metrics.WorkersLimit.Set(float64(cap(app.workerLimiter)))
+	app.pipeline = app.newPipeline()
+	err = app.pipeline.Configure(os.Getenv("PIPELINE_STAGES"))
+	if err != nil {
+		return nil, fmt.Errorf("configuring pipeline: %w", err)
 	}
+	app.dryRun = app.pipeline.Without("update", "dedupe", "persist", "publish", "remember")
+	return app, nil
 }
 
-func (a *App) processItem(text model.News) {
-	textEmbedding, err := a.embedding.GetEmbedding(text.Title, text.Description, text.FullText)
-	if err != nil {
-		a.logger.Error("Error getting embedding", "error", err)
+// workers reads WORKERS, the number of news processed concurrently
+func workers(logger interfaces.Logger) int {
+	workers, err := strconv.Atoi(os.Getenv("WORKERS"))
+	if err != nil || workers <= 0 {
+		logger.Info("Invalid WORKERS value, defaulting to 30")
+		workers = 30
+	}
+	return workers
+}
+
+// configVersion identifies settings which affect assignment decisions.
+// CONFIG_VERSION overrides the hash of the settings.
+func configVersion() string {
+	if version := os.Getenv("CONFIG_VERSION"); version != "" {
+		return version
+	}
+	h := fnv.New32a()
+	for _, name := range []string{"DIFF", "ALPHA", "DISTANCE", "LEXICAL_WEIGHT", "ENTITY_BOOST", "ENTITY_VETO", "DUPLICATE_DISTANCE", "PIPELINE_STAGES"} {
+		h.Write([]byte(name + "=" + os.Getenv(name) + ";"))
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// RegisterChecks adds readiness checks of all dependencies to the admin server
+func (a *App) RegisterChecks(server *admin.Server) {
+	server.AddCheck("postgres", a.db.Ping)
+	server.AddCheck("source", a.source.Ping)
+	server.AddCheck("sink", a.sink.Ping)
+	if a.clusters != nil {
+		server.AddCheck("clusters", a.clusters.Ping)
+	}
+	server.AddCheck("search", a.elastic.Ping)
+	server.AddCheck("embedding", a.embedding.Ping)
+}
+
+// RegisterAPI adds admin REST API to the admin server
+func (a *App) RegisterAPI(server *admin.Server) {
+	server.RegisterClusterAPI(a.db)
+	server.RegisterEditAPI(a.maker)
+	server.RegisterClassifyAPI(a)
+}
+
+// ClassifyOnly makes Run classify news without writing to Postgres, the index or
+// the outbox. Classifications are written to the sink as article.classified events.
+func (a *App) ClassifyOnly() {
+	a.classifyOnly = true
+}
+
+// SetWorkers overrides WORKERS, the number of news processed concurrently. Call before Run.
+func (a *App) SetWorkers(n int) {
+	a.workerLimiter = make(chan struct{}, n)
+	metrics.WorkersLimit.Set(float64(n))
+}
+
+func (a *App) processItem(ctx context.Context, text model.News) {
+	ctx = tracing.Extract(ctx, text.Trace)
+	ctx, span := tracing.Start(ctx, "process_item", trace.WithAttributes(attribute.Int64("news_id", text.ID)))
+	defer span.End()
+
+	if a.classifyOnly {
+		a.classifyItem(ctx, text)
 		return
 	}
-	similars, err := a.elastic.GetClosest(textEmbedding.GetArray(), 15)
+
+	err := a.pipeline.Run(ctx, &pipeline.Item{News: text})
 	if err != nil {
-		a.logger.Error("Error getting similars", "error", err)
-		return
+		// Ошибка стадии уже залогирована в pipeline.Logging
+		a.logger.Debug("News processing aborted", "error", err, "news_id", text.ID)
 	}
-	isRT := a.checker.CheckForRT(&text)
-	text.IsRT = isRT
-	found := false
-	for _, similar := range similars {
-
-		if similar.Distance <= math.Abs(1-a.maker.CalculateDynamicThresholdLogarithmicish(similar.NewsCount)) {
-			found = true
-			text.ClusterID = similar.ID
-			break
-		}
-	}
-	text.Embedding = textEmbedding.GetArray()
-	if !found {
-		err := a.maker.MakeNewGroup(&text)
-		if err != nil {
-			a.logger.Error("Error making new group", "error", err)
-			return
-		}
-	}
-	err = a.maker.SaveNews(text)
+}
+
+func (a *App) classifyItem(ctx context.Context, text model.News) {
+	result, err := a.Classify(ctx, text)
 	if err != nil {
-		a.logger.Error("Error saving news", "error", err)
+		a.logger.Debug("News classification aborted", "error", err, "news_id", text.ID)
 		return
 	}
+	err = transport.WriteJSON(ctx, a.sink, model.EventArticleClassified, strconv.FormatInt(text.ID, 10), model.ClassifiedNews{NewsID: text.ID, Classification: result})
+	if err != nil {
+		a.logger.Error("Error writing classification", "error", err, "news_id", text.ID)
+	}
+}
+
+// Classify runs embedding, search, RT check and assignment for the news
+// without writing to Postgres, the index or Kafka
+func (a *App) Classify(ctx context.Context, text model.News) (model.Classification, error) {
+	item := &pipeline.Item{News: text}
+	err := a.dryRun.Run(ctx, item)
+	if err != nil {
+		return model.Classification{}, err
+	}
+	result := model.Classification{
+		Cluster:    "new",
+		IsRT:       item.News.IsRT,
+		Candidates: item.Candidates,
+	}
+	if result.Candidates == nil {
+		result.Candidates = []model.Candidate{}
+	}
+	if item.Found {
+		result.ClusterID = item.News.ClusterID
+		result.Cluster = strconv.FormatInt(item.News.ClusterID, 10)
+	}
+	return result, nil
 }
 
 func (a *App) process() {
-	textInput := a.kafka.TextOutput()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	textInput := a.source.TextOutput()
+	if !a.classifyOnly {
+		go a.relay.Run(ctx)
+		go a.maker.RunSummaries(ctx)
+	}
 	go func() {
-		a.kafka.StartReadingText(context.Background())
+		a.source.StartReadingText(ctx)
 	}()
 	for text := range textInput {
 		a.workerLimiter <- struct{}{}
+		metrics.WorkersBusy.Inc()
 
 		a.wg.Add(1) // Увеличиваем счетчик WaitGroup
 		go func(item model.News) {
-			defer a.wg.Done()                    // Уменьшаем счетчик по завершении горутины
-			defer func() { <-a.workerLimiter }() // Освобождаем "токен" после завершения работы воркера
+			defer a.wg.Done() // Уменьшаем счетчик по завершении горутины
+			defer func() {
+				<-a.workerLimiter // Освобождаем "токен" после завершения работы воркера
+				metrics.WorkersBusy.Dec()
+			}()
+			defer func() {
+				if r := recover(); r != nil {
+					a.logger.Error("Panic in worker", "panic", r, "news_id", item.ID)
+				}
+				// Подтверждаем после обработки с любым исходом, упавшая новость не должна возвращаться по кругу
+				if item.Ack != nil {
+					item.Ack()
+				}
+			}()
 
-			a.processItem(item)
+			a.processItem(ctx, item)
 		}(text)
 	}
+	// Вход закрыт: дожидаемся воркеров и отправляем оставшееся в outbox
+	a.wg.Wait()
+	if !a.classifyOnly {
+		a.maker.FlushSummaries(ctx)
+		a.relay.Flush(ctx)
+	}
 }
 
 func (a *App) Run() {
@@ -0,0 +1,734 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"agregator/group/internal/endpoint/admin"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/outbox"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/summary"
+)
+
+func TestNewCluster(t *testing.T) {
+	h := newHarness(t)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 1 {
+		t.Fatalf("published %d articles, want 1", len(created))
+	}
+	news := created[0]
+	if news.ClusterID == 0 {
+		t.Fatal("cluster id is not set")
+	}
+	if news.Decision == nil || news.Decision.Outcome != model.OutcomeNew {
+		t.Fatalf("decision = %+v, want outcome %q", news.Decision, model.OutcomeNew)
+	}
+	if _, ok := h.search.documents()[news.ClusterID]; !ok {
+		t.Errorf("cluster %d is not registered in the index", news.ClusterID)
+	}
+	if feed, _ := h.store.Feed(1); !feed.Parsed {
+		t.Error("news is not marked as parsed")
+	}
+	if key := h.bus.Messages()[0].Headers[outbox.IdempotencyHeader]; key != "article.created:1" {
+		t.Errorf("idempotency key = %q", key)
+	}
+	if version := h.bus.Messages()[0].Headers[schema.VersionHeader]; version != "1" {
+		t.Errorf("schema version = %q, want 1", version)
+	}
+}
+
+func TestJoinCluster(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.handle(h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday"))
+	h.handle(h.item(3, "Football club wins the cup", "The final ended with a late goal"))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 3 {
+		t.Fatalf("published %d articles, want 3", len(created))
+	}
+	if created[1].ClusterID != created[0].ClusterID {
+		t.Errorf("second article is in cluster %d, want %d", created[1].ClusterID, created[0].ClusterID)
+	}
+	if created[1].Decision.Outcome != model.OutcomeJoined {
+		t.Errorf("outcome = %q, want %q", created[1].Decision.Outcome, model.OutcomeJoined)
+	}
+	if created[2].ClusterID == created[0].ClusterID {
+		t.Error("unrelated article joined the cluster")
+	}
+	cluster, err := h.store.GetCluster(context.Background(), uint64(created[0].ClusterID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.NewsCount != 2 {
+		t.Errorf("cluster has %d news, want 2", cluster.NewsCount)
+	}
+	if len(h.search.documents()) != 2 {
+		t.Errorf("index has %d clusters, want 2", len(h.search.documents()))
+	}
+}
+
+func TestEntityVetoWithoutSearchText(t *testing.T) {
+	h := newHarness(t)
+	h.search.dropText()
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Key rate raised in Moscow and Kazan", "local banks follow"))
+	h.handle(h.item(2, "Key rate raised in Paris and Lyon", "local banks follow"))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 2 {
+		t.Fatalf("published %d articles, want 2", len(created))
+	}
+	if created[1].ClusterID == created[0].ClusterID {
+		t.Error("article with other entities joined the cluster")
+	}
+	runnerUp := created[1].Decision.RunnerUp
+	if runnerUp == nil || !runnerUp.Vetoed {
+		t.Errorf("runner up = %+v, want vetoed candidate", runnerUp)
+	}
+}
+
+func TestClusterEvents(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.handle(h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday"))
+	h.handle(h.item(3, "Football club wins the cup", "The final ended with a late goal"))
+
+	events := h.clusterEvents()
+	if len(events) != 3 {
+		t.Fatalf("got %d cluster events, want 3", len(events))
+	}
+	first, joined, second := events[0], events[1], events[2]
+	if first.Event != model.EventClusterCreated || first.Size != 1 || first.FeedID != 1 {
+		t.Errorf("first event = %+v, want cluster.created of size 1", first)
+	}
+	if joined.Event != model.EventClusterUpdated || joined.ClusterID != first.ClusterID || joined.Size != 2 || joined.FeedID != 2 {
+		t.Errorf("second event = %+v, want cluster.updated of size 2", joined)
+	}
+	if len(joined.Centroid) != dimensions {
+		t.Errorf("centroid has %d dimensions, want %d", len(joined.Centroid), dimensions)
+	}
+	if second.Event != model.EventClusterCreated || second.ClusterID == first.ClusterID {
+		t.Errorf("third event = %+v, want another cluster.created", second)
+	}
+	if version := h.events.Messages()[0].Headers[schema.VersionHeader]; version != "1" {
+		t.Errorf("schema version = %q, want 1", version)
+	}
+
+	_, err := h.app.maker.Merge(context.Background(), second.ClusterID, first.ClusterID, "editor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.clusterEvents()) != 3 {
+		t.Fatal("merge events are written before the outbox relay runs")
+	}
+	h.app.relay.Flush(context.Background())
+	events = h.clusterEvents()[3:]
+	if len(events) != 3 {
+		t.Fatalf("got %d events after merge, want 3", len(events))
+	}
+	if events[0].Event != model.EventArticleMerged || events[0].ClusterID != first.ClusterID || events[0].Actor != "editor" {
+		t.Errorf("edit event = %+v", events[0])
+	}
+	if events[1].Event != model.EventClusterMerged || events[1].ClusterID != first.ClusterID || events[1].MergedFrom != second.ClusterID || events[1].Size != 3 {
+		t.Errorf("merge event = %+v", events[1])
+	}
+	if events[2].Event != model.EventClusterClosed || events[2].ClusterID != second.ClusterID || events[2].Reason != model.CloseReasonMerged {
+		t.Errorf("close event = %+v", events[2])
+	}
+	if _, ok := h.search.documents()[second.ClusterID]; ok {
+		t.Error("merged cluster is still in the index")
+	}
+	for _, message := range h.bus.Messages() {
+		if message.Event == model.EventArticleMerged {
+			t.Error("edit event is written to the news topic")
+		}
+	}
+}
+
+func TestRepresentative(t *testing.T) {
+	t.Setenv("REPRESENTATIVE_RULE", "longest")
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "Key rate is up"))
+	h.handle(h.item(2, "Bank of Russia raises key rate sharply", "Bank of Russia raises key rate by one point on Friday citing inflation"))
+	h.handle(h.item(3, "Bank of Russia raises key rate again", "The key rate was raised"))
+
+	events := h.clusterEvents()
+	last := events[len(events)-1]
+	if last.Size != 3 {
+		t.Fatalf("cluster has %d news, want 3", last.Size)
+	}
+	if last.RepresentativeID != 2 {
+		t.Errorf("representative = %d, want the longest news 2", last.RepresentativeID)
+	}
+	if last.Headline != "Bank of Russia raises key rate" {
+		t.Errorf("headline = %q", last.Headline)
+	}
+	cluster, err := h.store.GetCluster(context.Background(), uint64(last.ClusterID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.RepresentativeID != 2 || cluster.Headline != last.Headline {
+		t.Errorf("stored cluster = %+v", cluster)
+	}
+	doc := h.search.documents()[last.ClusterID]
+	if doc.Title != last.Headline || !strings.HasSuffix(doc.Description, "citing inflation") {
+		t.Errorf("index document = %+v", doc)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	t.Setenv("SUMMARY_SENTENCES", "2")
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "Bank of Russia raises key rate to 18 percent. Analysts expected the decision of the regulator."))
+	h.handle(h.item(2, "Bank of Russia raises key rate again", "Bank of Russia raises key rate by one point. The ruble strengthened after the news."))
+	h.handle(h.item(3, "Bank of Russia raises key rate sharply", "Bank of Russia raises key rate on Friday. Mortgage rates will follow the key rate."))
+
+	before := len(h.clusterEvents())
+	if last := h.clusterEvents()[before-1]; last.Size != 3 || last.Summary != "" {
+		t.Fatalf("last event before summary = %+v", last)
+	}
+	h.app.maker.FlushSummaries(context.Background())
+	h.app.relay.Flush(context.Background())
+
+	events := h.clusterEvents()
+	if len(events) != before+1 {
+		t.Fatalf("got %d events after summary, want %d", len(events), before+1)
+	}
+	last := events[len(events)-1]
+	if last.Event != model.EventClusterUpdated || last.Size != 3 {
+		t.Errorf("summary event = %+v", last)
+	}
+	if sentences := summary.Sentences(last.Summary); len(sentences) != 2 || !strings.HasPrefix(sentences[0], "Bank of Russia raises key rate") {
+		t.Errorf("summary = %q", last.Summary)
+	}
+	cluster, err := h.store.GetCluster(context.Background(), uint64(last.ClusterID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Summary != last.Summary {
+		t.Errorf("stored summary = %q, want %q", cluster.Summary, last.Summary)
+	}
+	want := fmt.Sprintf("cluster.updated:%d:summary:1", last.ClusterID)
+	if key := h.events.Messages()[len(events)-1].Headers[outbox.IdempotencyHeader]; key != want {
+		t.Errorf("idempotency key = %q, want %q", key, want)
+	}
+}
+
+func TestDuplicateFeedID(t *testing.T) {
+	h := newHarness(t)
+	item := h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point")
+	h.handle(item)
+	h.handle(item)
+
+	if created := h.published(model.EventArticleCreated); len(created) != 1 {
+		t.Fatalf("published %d articles, want 1", len(created))
+	}
+	if clusters := h.clusters(); clusters != 1 {
+		t.Errorf("store has %d clusters, want 1", clusters)
+	}
+}
+
+func TestDuplicateText(t *testing.T) {
+	h := newHarness(t)
+	text := "The regulator raised the key rate by one point to 17 percent at the meeting on Friday. " +
+		"Analysts expected the decision after inflation accelerated for the third month in a row " +
+		"and the national currency weakened against the dollar and the euro. " +
+		"The next meeting of the board of directors is scheduled for the end of July."
+	h.handle(h.item(1, "Bank of Russia raises key rate", text))
+	requests := h.embedding.count()
+	h.handle(h.item(2, "Bank of Russia raises key rate", text))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 2 {
+		t.Fatalf("published %d articles, want 2", len(created))
+	}
+	duplicate := created[1]
+	if !duplicate.IsDuplicate || duplicate.DuplicateOf != 1 {
+		t.Errorf("is_duplicate = %v, duplicate_of = %d", duplicate.IsDuplicate, duplicate.DuplicateOf)
+	}
+	if duplicate.ClusterID != created[0].ClusterID {
+		t.Errorf("duplicate is in cluster %d, want %d", duplicate.ClusterID, created[0].ClusterID)
+	}
+	if h.embedding.count() != requests {
+		t.Error("embedding was requested for a duplicate")
+	}
+}
+
+func TestUpdateSameCluster(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	item := h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday")
+	h.handle(item)
+	cluster := h.membership(2).GroupID
+	before := len(h.clusterEvents())
+
+	edited := h.edit(item, "Bank of Russia raised the key rate to 17 percent", "The regulator raised the key rate by one point on Friday")
+	h.handle(edited)
+	h.handle(edited)
+
+	if membership := h.membership(2); membership.GroupID != cluster {
+		t.Errorf("edited news is in cluster %d, want %d", membership.GroupID, cluster)
+	}
+	if clusters := h.clusters(); clusters != 1 {
+		t.Errorf("store has %d clusters, want 1", clusters)
+	}
+	updated := h.published(model.EventArticleUpdated)
+	if len(updated) != 1 || updated[0].ClusterID != int64(cluster) || updated[0].Title != edited.Title {
+		t.Fatalf("published updates %+v, want one update of the edited news", updated)
+	}
+	message := h.bus.Messages()[len(h.bus.Messages())-1]
+	if string(message.Key) != "2" || message.Headers[outbox.IdempotencyHeader] != "article.updated:2:md5-2-edit" {
+		t.Errorf("key = %q, idempotency key = %q", message.Key, message.Headers[outbox.IdempotencyHeader])
+	}
+	events := h.clusterEvents()[before:]
+	if len(events) != 1 || events[0].Event != model.EventClusterUpdated || events[0].ClusterID != int64(cluster) || events[0].Size != 2 {
+		t.Errorf("cluster events = %+v, want one cluster.updated of size 2", events)
+	}
+}
+
+func TestUpdateMovesNews(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.embedding.set("cup", 2)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	item := h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday")
+	h.handle(item)
+	h.handle(h.item(3, "Football club wins the cup", "The final ended with a late goal"))
+	previous, target := h.membership(1).GroupID, h.membership(3).GroupID
+	before := len(h.clusterEvents())
+
+	h.handle(h.edit(item, "Football club wins the cup again", "The final ended with a late goal again"))
+
+	if membership := h.membership(2); membership.GroupID != target {
+		t.Fatalf("edited news is in cluster %d, want %d", membership.GroupID, target)
+	}
+	for id, size := range map[uint64]int64{previous: 1, target: 2} {
+		cluster, err := h.store.GetCluster(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cluster.NewsCount != size {
+			t.Errorf("cluster %d has %d news, want %d", id, cluster.NewsCount, size)
+		}
+		centroid, err := h.store.GetCentroid(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc := h.search.documents()[int64(id)]; !reflect.DeepEqual(doc.Embedding, centroid.GetArray()) {
+			t.Errorf("index document of cluster %d has a stale centroid", id)
+		}
+	}
+	assignment, err := h.store.GetAssignment(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment.GroupID != target {
+		t.Errorf("assignment is in cluster %d, want %d", assignment.GroupID, target)
+	}
+	events := h.clusterEvents()[before:]
+	if len(events) != 2 || events[0].ClusterID != int64(target) || events[0].Size != 2 || events[1].ClusterID != int64(previous) || events[1].Size != 1 {
+		t.Errorf("cluster events = %+v, want updates of both clusters", events)
+	}
+}
+
+func TestUpdateCreatesCluster(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	item := h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday")
+	h.handle(item)
+	previous := h.membership(2).GroupID
+	before := len(h.clusterEvents())
+
+	h.handle(h.edit(item, "Открылась выставка", "Выставка продлится до конца месяца"))
+
+	membership := h.membership(2)
+	if membership.GroupID == previous || !membership.Founder {
+		t.Fatalf("membership = %+v, want a new cluster founded by the news", membership)
+	}
+	if _, ok := h.search.documents()[int64(membership.GroupID)]; !ok {
+		t.Error("new cluster is not registered in the index")
+	}
+	updated := h.published(model.EventArticleUpdated)
+	if len(updated) != 1 || updated[0].ClusterID != int64(membership.GroupID) {
+		t.Errorf("published updates %+v, want the new cluster", updated)
+	}
+	events := h.clusterEvents()[before:]
+	if len(events) != 2 || events[0].Event != model.EventClusterCreated || events[0].ClusterID != int64(membership.GroupID) || events[1].ClusterID != int64(previous) {
+		t.Errorf("cluster events = %+v, want cluster.created and cluster.updated", events)
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	item := h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday")
+	h.handle(item)
+	previous := h.membership(2).GroupID
+	centroid, err := h.store.GetCentroid(context.Background(), uint64(previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, events := len(h.bus.Messages()), len(h.clusterEvents())
+
+	edited := h.edit(item, "Открылась выставка", "Выставка продлится до конца месяца")
+	h.faults.fail(errors.New("connection lost"))
+	h.handle(edited)
+	h.faults.fail(nil)
+
+	if membership := h.membership(2); membership.GroupID != previous {
+		t.Errorf("news moved to cluster %d by the failed update", membership.GroupID)
+	}
+	if clusters := h.clusters(); clusters != 1 {
+		t.Errorf("store has %d clusters after the failed update, want 1", clusters)
+	}
+	if after, _ := h.store.GetCentroid(context.Background(), uint64(previous)); !reflect.DeepEqual(after, centroid) {
+		t.Error("centroid changed by the failed update")
+	}
+	if len(h.bus.Messages()) != messages || len(h.clusterEvents()) != events || len(h.search.documents()) != 1 {
+		t.Error("failed update wrote side effects")
+	}
+
+	h.handle(edited)
+	if membership := h.membership(2); membership.GroupID == previous {
+		t.Error("retried update did not move the news")
+	}
+	if updated := h.published(model.EventArticleUpdated); len(updated) != 1 {
+		t.Errorf("published %d updates after retry, want 1", len(updated))
+	}
+}
+
+func TestDuplicateAfterMerge(t *testing.T) {
+	h := newHarness(t)
+	text := "The regulator raised the key rate by one point to 17 percent at the meeting on Friday. " +
+		"Analysts expected the decision after inflation accelerated for the third month in a row " +
+		"and the national currency weakened against the dollar and the euro."
+	h.handle(h.item(1, "Bank of Russia raises key rate", text))
+	h.handle(h.item(2, "Football club wins the cup", "The final ended with a late goal"))
+	source, target := h.membership(1).GroupID, h.membership(2).GroupID
+	if _, err := h.app.maker.Merge(context.Background(), int64(source), int64(target), "editor"); err != nil {
+		t.Fatal(err)
+	}
+	centroid, err := h.store.GetCentroid(context.Background(), uint64(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.handle(h.item(3, "Bank of Russia raises key rate", text))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 3 || !created[2].IsDuplicate || created[2].ClusterID != int64(target) {
+		t.Fatalf("published %+v, want a duplicate in the merged cluster %d", created, target)
+	}
+	if after, _ := h.store.GetCentroid(context.Background(), uint64(target)); !reflect.DeepEqual(after, centroid) {
+		t.Error("duplicate moved the centroid")
+	}
+	assignment, err := h.store.GetAssignment(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assignment.DuplicateOf != 1 {
+		t.Errorf("assignment duplicate_of = %d, want 1", assignment.DuplicateOf)
+	}
+}
+
+func TestRTDetection(t *testing.T) {
+	h := newHarness(t, "молния")
+	h.handle(h.item(1, "Молния: землетрясение в Японии", "Сила толчков составила 6 баллов"))
+	h.handle(h.item(2, "Открылась выставка", "Выставка продлится до конца месяца"))
+
+	created := h.published(model.EventArticleCreated)
+	if len(created) != 2 {
+		t.Fatalf("published %d articles, want 2", len(created))
+	}
+	if !created[0].IsRT {
+		t.Error("article with RT word is not marked as RT")
+	}
+	if created[1].IsRT {
+		t.Error("article without RT words is marked as RT")
+	}
+}
+
+func TestEmbeddingError(t *testing.T) {
+	h := newHarness(t)
+	item := h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point")
+	h.embedding.fail(http.StatusInternalServerError)
+	h.handle(item)
+
+	if messages := h.bus.Messages(); len(messages) != 0 {
+		t.Fatalf("published %d messages, want none", len(messages))
+	}
+	if clusters := h.clusters(); clusters != 0 {
+		t.Errorf("store has %d clusters, want none", clusters)
+	}
+	if feed, _ := h.store.Feed(1); feed.Parsed {
+		t.Error("failed news is marked as parsed")
+	}
+
+	h.embedding.fail(0)
+	h.handle(item)
+	if created := h.published(model.EventArticleCreated); len(created) != 1 {
+		t.Errorf("published %d articles after recovery, want 1", len(created))
+	}
+}
+
+func TestSearchError(t *testing.T) {
+	h := newHarness(t)
+	h.search.fail(http.StatusServiceUnavailable)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+
+	if messages := h.bus.Messages(); len(messages) != 0 {
+		t.Fatalf("published %d messages, want none", len(messages))
+	}
+	if clusters := h.clusters(); clusters != 0 {
+		t.Errorf("store has %d clusters, want none", clusters)
+	}
+}
+
+func TestIndexErrorKeepsOutput(t *testing.T) {
+	h := newHarness(t)
+	h.search.failRegister(http.StatusInternalServerError)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+
+	if created := h.published(model.EventArticleCreated); len(created) != 1 {
+		t.Fatalf("published %d articles, want 1", len(created))
+	}
+	if len(h.search.documents()) != 0 {
+		t.Error("cluster is registered in the failing index")
+	}
+	pending, err := h.store.PendingOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 {
+		t.Errorf("%d pending outbox entries, want 1", pending)
+	}
+}
+
+func TestOutboxKeepsKeyOrder(t *testing.T) {
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.events.FailWrites(errors.New("broker is down"))
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.events.FailWrites(nil)
+	h.handle(h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday"))
+
+	if created := h.published(model.EventArticleCreated); len(created) != 2 || created[1].ClusterID != created[0].ClusterID {
+		t.Fatalf("published %+v, want two articles of one cluster", created)
+	}
+	// cluster.updated ждет повторной отправки cluster.created того же кластера
+	if events := h.clusterEvents(); len(events) != 0 {
+		t.Errorf("got cluster events %+v before cluster.created was retried", events)
+	}
+	pending, err := h.store.PendingOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 2 {
+		t.Errorf("%d pending outbox entries, want 2", pending)
+	}
+}
+
+func TestOutboxGivesUp(t *testing.T) {
+	t.Setenv("OUTBOX_MAX_ATTEMPTS", "1")
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.events.FailWrites(errors.New("broker is down"))
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.events.FailWrites(nil)
+	h.handle(h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday"))
+
+	// cluster.created отброшен, поэтому больше не задерживает события кластера
+	events := h.clusterEvents()
+	if len(events) != 1 || events[0].Event != model.EventClusterUpdated || events[0].Size != 2 {
+		t.Errorf("cluster events = %+v, want cluster.updated after the failed cluster.created", events)
+	}
+	pending, err := h.store.PendingOutbox(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending != 0 {
+		t.Errorf("%d pending outbox entries, want none", pending)
+	}
+}
+
+func TestClassifyOnly(t *testing.T) {
+	h := newHarness(t)
+	h.app.ClassifyOnly()
+	h.bus.Publish(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.bus.Close()
+	h.app.Run()
+
+	messages := h.bus.Messages()
+	if len(messages) != 1 || messages[0].Event != model.EventArticleClassified {
+		t.Fatalf("written messages = %+v, want one article.classified", messages)
+	}
+	if clusters := h.clusters(); clusters != 0 {
+		t.Errorf("store has %d clusters, want none", clusters)
+	}
+	if len(h.search.documents()) != 0 {
+		t.Error("dry run registered a cluster in the index")
+	}
+}
+
+func TestEditAPIRequiresToken(t *testing.T) {
+	t.Setenv("ADMIN_TOKENS", "alice:secret, bob:other")
+	h := newHarness(t)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	server := admin.New("", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.app.RegisterAPI(server)
+
+	pin := func(token string) int {
+		request := httptest.NewRequest(http.MethodPost, "/news/1/pin", nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if code := pin(""); code != http.StatusUnauthorized {
+		t.Errorf("without token: status %d, want 401", code)
+	}
+	if code := pin("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("with unknown token: status %d, want 401", code)
+	}
+	if code := pin("secret"); code != http.StatusOK {
+		t.Fatalf("with token: status %d, want 200", code)
+	}
+	if audit := h.store.Audit(); len(audit) != 1 || audit[0].Actor != "alice" {
+		t.Errorf("audit = %+v, want one entry of alice", audit)
+	}
+}
+
+func TestEditRollsBack(t *testing.T) {
+	t.Setenv("ADMIN_TOKENS", "alice:secret")
+	h := newHarness(t)
+	h.embedding.set("key rate", 1)
+	h.handle(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.handle(h.item(2, "Bank of Russia raised the key rate", "The regulator raised the key rate by one point on Friday"))
+	h.handle(h.item(3, "Football club wins the cup", "The final ended with a late goal"))
+	previous, target := h.membership(2).GroupID, h.membership(3).GroupID
+	centroid, err := h.store.GetCentroid(context.Background(), uint64(previous))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := len(h.clusterEvents())
+	server := admin.New("", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	h.app.RegisterAPI(server)
+	move := func() int {
+		request := httptest.NewRequest(http.MethodPost, "/news/2/move", strings.NewReader(fmt.Sprintf(`{"cluster_id": %d}`, target)))
+		request.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		server.Handler().ServeHTTP(recorder, request)
+		h.app.relay.Flush(context.Background())
+		return recorder.Code
+	}
+
+	h.faults.fail(errors.New("connection lost"))
+	if code := move(); code != http.StatusInternalServerError {
+		t.Fatalf("failed move: status %d, want 500", code)
+	}
+	h.faults.fail(nil)
+	if membership := h.membership(2); membership.GroupID != previous {
+		t.Errorf("news moved to cluster %d by the failed edit", membership.GroupID)
+	}
+	if after, _ := h.store.GetCentroid(context.Background(), uint64(previous)); !reflect.DeepEqual(after, centroid) {
+		t.Error("centroid changed by the failed edit")
+	}
+	if audit := h.store.Audit(); len(audit) != 0 {
+		t.Errorf("audit = %+v after the failed edit, want none", audit)
+	}
+	if len(h.clusterEvents()) != events {
+		t.Error("failed edit wrote events")
+	}
+
+	if code := move(); code != http.StatusOK {
+		t.Fatalf("retried move: status %d, want 200", code)
+	}
+	if membership := h.membership(2); membership.GroupID != target {
+		t.Errorf("news is in cluster %d after the edit, want %d", membership.GroupID, target)
+	}
+	audit := h.store.Audit()
+	if len(audit) != 1 {
+		t.Fatalf("audit = %+v, want one entry", audit)
+	}
+	message := h.events.Messages()[events]
+	want := fmt.Sprintf("article.moved:%d:audit:%d", target, audit[0].ID)
+	if message.Event != model.EventArticleMoved || message.Headers[outbox.IdempotencyHeader] != want {
+		t.Errorf("edit event %q with idempotency key %q, want %q", message.Event, message.Headers[outbox.IdempotencyHeader], want)
+	}
+}
+
+func TestRunConsumesBus(t *testing.T) {
+	h := newHarness(t)
+	h.bus.Publish(h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point"))
+	h.bus.Publish(h.item(2, "Football club wins the cup", "The final ended with a late goal"))
+	h.bus.Publish(h.item(3, "Открылась выставка", "Выставка продлится до конца месяца"))
+	h.bus.Close()
+
+	done := make(chan struct{})
+	go func() {
+		h.app.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run did not return after the input was closed")
+	}
+	if created := h.published(model.EventArticleCreated); len(created) != 3 {
+		t.Errorf("published %d articles, want 3", len(created))
+	}
+	acked := h.bus.Acked()
+	sort.Slice(acked, func(i, j int) bool { return acked[i] < acked[j] })
+	if !reflect.DeepEqual(acked, []int64{1, 2, 3}) {
+		t.Errorf("acknowledged %v, want [1 2 3]", acked)
+	}
+}
+
+func TestAckAfterProcessing(t *testing.T) {
+	h := newHarness(t)
+	clustered := false
+	news := h.item(1, "Bank of Russia raises key rate", "The regulator raised the key rate by one point").News()
+	news.Ack = func() {
+		membership, err := h.store.GetGroupByFeed(context.Background(), 1)
+		clustered = err == nil && membership.GroupID != 0
+	}
+	h.bus.PublishNews(news, nil)
+	h.bus.Close()
+	h.app.Run()
+	if !clustered {
+		t.Error("news was acknowledged before it was clustered")
+	}
+
+	// Неудачно обработанная новость тоже подтверждается, чтобы не возвращаться по кругу
+	failed := newHarness(t)
+	failed.embedding.fail(http.StatusInternalServerError)
+	failed.bus.Publish(failed.item(2, "Football club wins the cup", "The final ended with a late goal"))
+	failed.bus.Close()
+	failed.app.Run()
+	if acked := failed.bus.Acked(); !reflect.DeepEqual(acked, []int64{2}) {
+		t.Errorf("acknowledged %v, want [2]", acked)
+	}
+}
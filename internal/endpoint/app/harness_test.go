@@ -0,0 +1,395 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cfg "agregator/group/internal/config/yandex/embedding"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/db/memory"
+	"agregator/group/internal/service/elastic"
+	bus "agregator/group/internal/service/kafka/memory"
+	"agregator/group/internal/service/rtchecker"
+	"agregator/group/service/vector"
+)
+
+const dimensions = 64
+
+// harness wires App to in-memory store and bus and to fake embedding and search servers
+type harness struct {
+	t         *testing.T
+	app       *App
+	store     *memory.Store
+	faults    *faultyStore
+	bus       *bus.Bus
+	events    *bus.Bus
+	embedding *fakeEmbedding
+	search    *fakeSearch
+}
+
+func newHarness(t *testing.T, rtWords ...string) *harness {
+	t.Helper()
+	store := memory.New()
+	store.SetRTWords(rtWords)
+	h := &harness{
+		t:         t,
+		store:     store,
+		faults:    &faultyStore{Store: store},
+		bus:       bus.New(100),
+		events:    bus.New(0),
+		embedding: newFakeEmbedding(),
+		search:    newFakeSearch(store),
+	}
+	embeddingServer := httptest.NewServer(h.embedding)
+	t.Cleanup(embeddingServer.Close)
+	searchServer := httptest.NewServer(h.search)
+	t.Cleanup(searchServer.Close)
+	t.Setenv("YANDEX_EMBEDDING_URL", embeddingServer.URL)
+	t.Setenv("ELASTIC_HOST", searchServer.URL)
+	t.Setenv("MAX_REQUESTS", "4")
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	checker, err := rtchecker.New(store, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.app, err = New(h.faults, h.bus, h.bus, h.events, 0.1, 0.2, 0.5, time.Second, checker, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// item creates a feed item and adds it to the feed table
+func (h *harness) item(id int, title, description string) model.Item {
+	date := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Minute)
+	h.store.AddFeed(memory.Feed{ID: uint64(id), Title: title, Description: description})
+	return model.Item{
+		ID:          id,
+		Title:       title,
+		Description: description,
+		PubDate:     &date,
+		MD5:         fmt.Sprintf("md5-%d", id),
+		Link:        fmt.Sprintf("https://example.com/%d", id),
+	}
+}
+
+// edit returns the item changed by the source: new text and MD5 in the feed table
+// and the changed flag set
+func (h *harness) edit(item model.Item, title, description string) model.Item {
+	h.store.AddFeed(memory.Feed{ID: uint64(item.ID), Title: title, Description: description, Parsed: true})
+	item.Title = title
+	item.Description = description
+	item.MD5 += "-edit"
+	item.Changed = true
+	return item
+}
+
+// membership returns the cluster of the news
+func (h *harness) membership(id int) db.Membership {
+	h.t.Helper()
+	membership, err := h.store.GetGroupByFeed(context.Background(), uint64(id))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return membership
+}
+
+// handle processes the item and delivers outbox entries synchronously
+func (h *harness) handle(item model.Item) {
+	h.app.processItem(context.Background(), item.News())
+	h.app.relay.Flush(context.Background())
+}
+
+// published returns news written to the output topic with the event
+func (h *harness) published(event string) []model.News {
+	h.t.Helper()
+	result := []model.News{}
+	for _, message := range h.bus.Messages() {
+		if message.Event != event {
+			continue
+		}
+		news, err := message.News()
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		result = append(result, news)
+	}
+	return result
+}
+
+// clusterEvents returns events written to the cluster events topic
+func (h *harness) clusterEvents() []model.ClusterEvent {
+	h.t.Helper()
+	result := []model.ClusterEvent{}
+	for _, message := range h.events.Messages() {
+		var event model.ClusterEvent
+		if err := json.Unmarshal(message.Value, &event); err != nil {
+			h.t.Fatal(err)
+		}
+		if event.Event != message.Event {
+			h.t.Fatalf("event %q in message %q", event.Event, message.Event)
+		}
+		result = append(result, event)
+	}
+	return result
+}
+
+func (h *harness) clusters() int {
+	h.t.Helper()
+	clusters, err := h.store.ListClusters(context.Background(), db.ClusterFilter{})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return len(clusters)
+}
+
+// faultyStore fails AddOutbox of transactions while the error is set, so a unit
+// of work breaks after its data changes are made
+type faultyStore struct {
+	*memory.Store
+	mu  sync.Mutex
+	err error
+}
+
+func (s *faultyStore) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *faultyStore) fault() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *faultyStore) Begin(ctx context.Context) (db.Tx, error) {
+	tx, err := s.Store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyTx{Tx: tx, store: s}, nil
+}
+
+type faultyTx struct {
+	db.Tx
+	store *faultyStore
+}
+
+func (t *faultyTx) AddOutbox(ctx context.Context, entry db.OutboxEntry) error {
+	if err := t.store.fault(); err != nil {
+		return err
+	}
+	return t.Tx.AddOutbox(ctx, entry)
+}
+
+// fakeEmbedding emulates Yandex text embedding API. Text containing a registered
+// keyword gets its vector, any other text gets a one-hot vector of its hash.
+type fakeEmbedding struct {
+	mu       sync.Mutex
+	vectors  map[string][]float64
+	status   int
+	requests int
+}
+
+func newFakeEmbedding() *fakeEmbedding {
+	return &fakeEmbedding{vectors: make(map[string][]float64)}
+}
+
+func (f *fakeEmbedding) set(keyword string, axis int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.vectors[keyword] = oneHot(axis)
+}
+
+func (f *fakeEmbedding) fail(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *fakeEmbedding) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *fakeEmbedding) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.status != 0 {
+		http.Error(w, "provider error", f.status)
+		return
+	}
+	var request cfg.Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	keywords := make([]string, 0, len(f.vectors))
+	for keyword := range f.vectors {
+		keywords = append(keywords, keyword)
+	}
+	sort.Strings(keywords)
+	embedding := []float64(nil)
+	for _, keyword := range keywords {
+		if strings.Contains(strings.ToLower(request.Text), keyword) {
+			embedding = f.vectors[keyword]
+			break
+		}
+	}
+	if embedding == nil {
+		h := fnv.New32a()
+		h.Write([]byte(request.Text))
+		embedding = oneHot(int(h.Sum32() % dimensions))
+	}
+	json.NewEncoder(w).Encode(cfg.Response{Embedding: embedding, NumTokens: "1", ModelVersion: "test"})
+}
+
+func oneHot(axis int) []float64 {
+	embedding := make([]float64, dimensions)
+	embedding[axis%dimensions] = 1
+	return embedding
+}
+
+// fakeSearch emulates the search sidecar: /get returns registered clusters
+// ordered by cosine distance, news count is taken from the store
+type fakeSearch struct {
+	mu          sync.Mutex
+	store       *memory.Store
+	docs        map[int64]elastic.Document
+	status      int
+	registerErr int
+	// withoutText makes /get answer like the real sidecar which does not return cluster text
+	withoutText bool
+}
+
+func newFakeSearch(store *memory.Store) *fakeSearch {
+	return &fakeSearch{store: store, docs: make(map[int64]elastic.Document)}
+}
+
+func (f *fakeSearch) fail(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+func (f *fakeSearch) dropText() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.withoutText = true
+}
+
+func (f *fakeSearch) failRegister(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.registerErr = status
+}
+
+func (f *fakeSearch) documents() map[int64]elastic.Document {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	docs := make(map[int64]elastic.Document, len(f.docs))
+	for id, doc := range f.docs {
+		docs[id] = doc
+	}
+	return docs
+}
+
+func (f *fakeSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.WriteHeader(http.StatusOK)
+	case "/get":
+		f.get(w, r)
+	case "/register":
+		f.register(w, r)
+	case "/delete":
+		var request struct {
+			ID int64 `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		f.mu.Lock()
+		delete(f.docs, request.ID)
+		f.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSearch) get(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Embedding []float64 `json:"embedding"`
+		Limit     int       `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	if f.status != 0 {
+		f.mu.Unlock()
+		http.Error(w, "search error", f.status)
+		return
+	}
+	docs := make([]elastic.Document, 0, len(f.docs))
+	for _, doc := range f.docs {
+		if f.withoutText {
+			doc.Title, doc.Description, doc.Rewrite = "", "", ""
+		}
+		docs = append(docs, doc)
+	}
+	f.mu.Unlock()
+
+	query := vector.New(request.Embedding)
+	items := make([]model.Cluster, 0, len(docs))
+	for _, doc := range docs {
+		cluster, err := f.store.GetCluster(r.Context(), uint64(doc.Id))
+		if err != nil {
+			continue
+		}
+		items = append(items, model.Cluster{
+			ID:          doc.Id,
+			NewsCount:   cluster.NewsCount,
+			Distance:    1 - query.CosDistance(vector.New(doc.Embedding)),
+			IsRT:        cluster.IsRT,
+			Title:       doc.Title,
+			Description: doc.Description,
+			Text:        doc.Rewrite,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Distance < items[j].Distance })
+	if request.Limit > 0 && len(items) > request.Limit {
+		items = items[:request.Limit]
+	}
+	json.NewEncoder(w).Encode(map[string]any{"items": items})
+}
+
+func (f *fakeSearch) register(w http.ResponseWriter, r *http.Request) {
+	var doc elastic.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.registerErr != 0 {
+		http.Error(w, "register error", f.registerErr)
+		return
+	}
+	f.docs[doc.Id] = doc
+}
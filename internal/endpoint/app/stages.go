@@ -0,0 +1,297 @@
+package app
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/duplicate"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/pipeline"
+)
+
+const (
+	assignStrategy    = "hybrid_dynamic_log_threshold"
+	duplicateStrategy = "simhash"
+)
+
+// newPipeline builds default processing order:
+// update → dedupe → embed → search → rt → assign → persist → publish → remember
+func (a *App) newPipeline() *pipeline.Pipeline {
+	p := pipeline.New(a.logger,
+		pipeline.NewStage("update", a.updateStage),
+		pipeline.NewStage("dedupe", a.dedupeStage),
+		pipeline.NewStage("embed", a.embedStage),
+		pipeline.NewStage("search", a.searchStage),
+		pipeline.NewStage("rt", a.rtStage),
+		pipeline.NewStage("assign", a.assignStage),
+		pipeline.NewStage("persist", a.persistStage),
+		pipeline.NewStage("publish", a.publishStage),
+		pipeline.NewStage("remember", a.rememberStage),
+	)
+	p.Use(pipeline.Recovery(a.logger), pipeline.Tracing(), pipeline.Metrics(), pipeline.Timing(a.logger), pipeline.Logging(a.logger))
+	return p
+}
+
+func (a *App) updateStage(ctx context.Context, item *pipeline.Item) error {
+	if !item.News.Changed {
+		return nil
+	}
+	membership, err := a.maker.CurrentCluster(ctx, item.News.ID)
+	if err != nil {
+		return err
+	}
+	if membership.GroupID == 0 {
+		// Новость еще не была кластеризована, обрабатываем как новую
+		item.News.Changed = false
+		return nil
+	}
+	// Основатель и закрепленная редактором новость всегда остаются в кластере
+	keep := membership.Founder || membership.Pinned
+	return a.processUpdate(ctx, item.News, int64(membership.GroupID), keep)
+}
+
+func (a *App) dedupeStage(ctx context.Context, item *pipeline.Item) error {
+	item.Hash, item.Hashed = duplicate.Hash(&item.News)
+	if !item.Hashed {
+		return nil
+	}
+	original, ok := a.duplicates.Find(item.Hash)
+	if !ok {
+		return nil
+	}
+	// Кластер оригинала берется из базы, редактор мог перенести его или слить кластеры
+	membership, err := a.maker.CurrentCluster(ctx, original.NewsID)
+	if err != nil {
+		return err
+	}
+	if membership.GroupID == 0 {
+		a.logger.Debug("Original of near-duplicate is not clustered", "news_id", item.News.ID, "original_id", original.NewsID)
+		return nil
+	}
+	item.News.IsDuplicate = true
+	item.News.DuplicateOf = original.NewsID
+	item.News.ClusterID = int64(membership.GroupID)
+	item.News.IsRT = original.IsRT
+	item.News.Embedding = original.Embedding
+	item.News.Decision = &model.Decision{
+		Outcome:       model.OutcomeDuplicate,
+		Strategy:      duplicateStrategy,
+		ConfigVersion: a.configVersion,
+		Distance:      float64(bits.OnesCount64(original.Hash^item.Hash)) / 64,
+		Similarity:    1 - float64(bits.OnesCount64(original.Hash^item.Hash))/64,
+	}
+	item.Found = true
+	a.logger.Debug("Near-duplicate found", "news_id", item.News.ID, "original_id", original.NewsID, "cluster_id", item.News.ClusterID)
+	return nil
+}
+
+func (a *App) embedStage(ctx context.Context, item *pipeline.Item) error {
+	if item.News.IsDuplicate {
+		return nil
+	}
+	textEmbedding, err := a.embedding.GetEmbedding(ctx, item.News.Title, item.News.Description, item.News.FullText)
+	if err != nil {
+		return err
+	}
+	item.Embedding = textEmbedding
+	item.News.Embedding = textEmbedding.GetArray()
+	return nil
+}
+
+func (a *App) searchStage(ctx context.Context, item *pipeline.Item) error {
+	if item.News.IsDuplicate {
+		return nil
+	}
+	similars, err := a.elastic.GetClosest(ctx, item.News.Embedding, 15)
+	if err != nil {
+		return err
+	}
+	item.Similars = a.withText(ctx, similars)
+	return nil
+}
+
+// withText fills in the representative text of candidates the search sidecar returned without it,
+// otherwise lexical and entity scoring would silently be zero
+func (a *App) withText(ctx context.Context, similars []model.Cluster) []model.Cluster {
+	for i := range similars {
+		similar := &similars[i]
+		if strings.TrimSpace(similar.Title+similar.Description) != "" {
+			metrics.CandidateText.WithLabelValues("search").Inc()
+			continue
+		}
+		doc, err := a.db.GetClusterDocument(ctx, uint64(similar.ID))
+		if err != nil {
+			metrics.CandidateText.WithLabelValues("missing").Inc()
+			a.logger.Warn("Error loading cluster text, scoring by vector distance only", "cluster_id", similar.ID, "error", err)
+			continue
+		}
+		metrics.CandidateText.WithLabelValues("store").Inc()
+		similar.Title = doc.Title.String
+		similar.Description = doc.Description.String
+		similar.Text = doc.FullText.String
+	}
+	return similars
+}
+
+func (a *App) rtStage(ctx context.Context, item *pipeline.Item) error {
+	if item.News.IsDuplicate {
+		return nil
+	}
+	item.News.IsRT = a.checker.CheckForRT(&item.News)
+	metrics.RTChecks.WithLabelValues(strconv.FormatBool(item.News.IsRT)).Inc()
+	return nil
+}
+
+func (a *App) assignStage(ctx context.Context, item *pipeline.Item) error {
+	if item.Found {
+		return nil
+	}
+	for _, similar := range item.Similars {
+		candidate := a.evaluate(&item.News, similar)
+		item.Candidates = append(item.Candidates, candidate)
+		if candidate.Matched && !item.Found {
+			item.Found = true
+			item.News.ClusterID = similar.ID
+		}
+	}
+	item.News.Decision = a.decide(item)
+	return nil
+}
+
+// decide builds the decision record: the chosen candidate and the best of the others,
+// or the closest rejected candidate when a new cluster is created
+func (a *App) decide(item *pipeline.Item) *model.Decision {
+	decision := &model.Decision{
+		Outcome:       model.OutcomeNew,
+		Strategy:      assignStrategy,
+		ConfigVersion: a.configVersion,
+	}
+	var chosen, runnerUp *model.Candidate
+	for i := range item.Candidates {
+		candidate := &item.Candidates[i]
+		if item.Found && chosen == nil && candidate.ClusterID == item.News.ClusterID {
+			chosen = candidate
+			continue
+		}
+		if runnerUp == nil || candidate.Score < runnerUp.Score {
+			runnerUp = candidate
+		}
+	}
+	if chosen != nil {
+		decision.Outcome = model.OutcomeJoined
+		decision.Distance = chosen.Score
+		decision.Similarity = 1 - chosen.Score
+		decision.Threshold = chosen.Threshold
+		decision.ClusterSize = chosen.NewsCount
+	} else if runnerUp != nil {
+		decision.Distance = runnerUp.Score
+		decision.Similarity = 1 - runnerUp.Score
+		decision.Threshold = runnerUp.Threshold
+	}
+	if runnerUp != nil {
+		value := *runnerUp
+		decision.RunnerUp = &value
+	}
+	return decision
+}
+
+func (a *App) persistStage(ctx context.Context, item *pipeline.Item) error {
+	result := "joined"
+	if item.News.IsDuplicate {
+		result = "duplicate"
+	}
+	if !item.Found {
+		result = "new"
+	}
+	err := a.maker.Save(ctx, &item.News, item.Candidates, !item.Found)
+	if err != nil {
+		return err
+	}
+	metrics.Assignments.WithLabelValues(result).Inc()
+	return nil
+}
+
+// publishStage wakes the outbox relay up, the message itself is written in persistStage
+func (a *App) publishStage(ctx context.Context, item *pipeline.Item) error {
+	a.relay.Notify()
+	return nil
+}
+
+func (a *App) rememberStage(ctx context.Context, item *pipeline.Item) error {
+	if item.Hashed && !item.News.IsDuplicate {
+		a.duplicates.Add(item.Hash, &item.News)
+	}
+	return nil
+}
+
+func (a *App) evaluate(text *model.News, similar model.Cluster) model.Candidate {
+	score := a.scorer.Score(text, similar)
+	threshold := a.maker.CalculateDynamicThresholdLogarithmicish(similar.NewsCount)
+	candidate := model.Candidate{
+		ClusterID:           similar.ID,
+		NewsCount:           similar.NewsCount,
+		Distance:            similar.Distance,
+		Score:               score.Distance,
+		Threshold:           math.Abs(1 - threshold),
+		SimilarityThreshold: threshold,
+		Vetoed:              score.Veto,
+	}
+	if score.Veto {
+		a.logger.Debug("Cluster vetoed by entity check", "cluster_id", similar.ID, "news_id", text.ID)
+		return candidate
+	}
+	candidate.Matched = candidate.Score <= candidate.Threshold
+	return candidate
+}
+
+// processUpdate re-embeds edited news and keeps it in its cluster, moves it to another one
+// or splits it out into a new cluster. Always stops the pipeline.
+func (a *App) processUpdate(ctx context.Context, text model.News, previous int64, keep bool) error {
+	textEmbedding, err := a.embedding.GetEmbedding(ctx, text.Title, text.Description, text.FullText)
+	if err != nil {
+		return err
+	}
+	text.Embedding = textEmbedding.GetArray()
+	text.IsRT = a.checker.CheckForRT(&text)
+	text.ClusterID = previous
+
+	newGroup := false
+	if !keep {
+		similars, err := a.elastic.GetClosest(ctx, text.Embedding, 15)
+		if err != nil {
+			return err
+		}
+		similars = a.withText(ctx, similars)
+		var target int64
+		for _, similar := range similars {
+			if !a.evaluate(&text, similar).Matched {
+				continue
+			}
+			if similar.ID == previous {
+				target = previous
+				break
+			}
+			if target == 0 {
+				target = similar.ID
+			}
+		}
+		if target == 0 {
+			newGroup = true
+		} else {
+			text.ClusterID = target
+		}
+	}
+
+	err = a.maker.UpdateNews(ctx, &text, previous, newGroup)
+	if err != nil {
+		return err
+	}
+	a.relay.Notify()
+	metrics.Assignments.WithLabelValues("updated").Inc()
+	a.logger.Info("News updated", "news_id", text.ID, "cluster_id", text.ClusterID, "previous_cluster_id", previous)
+	return pipeline.ErrStop
+}
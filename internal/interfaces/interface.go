@@ -1,8 +1,46 @@
 package interfaces
 
+import (
+	"context"
+
+	model "agregator/group/internal/model/kafka"
+)
+
 type Logger interface {
 	Info(msg string, args ...any)
 	Debug(msg string, args ...any)
 	Error(msg string, args ...any)
 	Warn(msg string, args ...any)
 }
+
+// Source delivers news from the input topic into TextOutput until ctx is done
+// or the input ends, then closes the channel. Delivery is at least once: sources
+// which acknowledge input set News.Ack, the consumer calls it after processing,
+// news in the channel or in processing are delivered again if the process crashes.
+type Source interface {
+	TextOutput() <-chan model.News
+	StartReadingText(ctx context.Context)
+	Ping(ctx context.Context) error
+}
+
+// Sink writes encoded events to the output topic. Headers carry the event type,
+// idempotency key and trace context where the transport supports them.
+type Sink interface {
+	WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) error
+	Ping(ctx context.Context) error
+}
+
+// Message is an encoded event for BatchSink
+type Message struct {
+	Event   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// BatchSink is a Sink writing several messages in one request. Messages keep
+// their order within a key. On error some of the messages may have been written.
+type BatchSink interface {
+	Sink
+	WriteMessages(ctx context.Context, messages []Message) error
+}
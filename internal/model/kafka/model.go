@@ -4,6 +4,25 @@ import (
 	"time"
 )
 
+const (
+	EventArticleCreated = "article.created"
+	EventArticleUpdated = "article.updated"
+	// EventArticleClassified is written by dry-run replay instead of article.created
+	EventArticleClassified = "article.classified"
+
+	EventArticleMoved    = "article.moved"
+	EventArticleDetached = "article.detached"
+	EventArticlePinned   = "article.pinned"
+	// EventArticleMerged is the editor request which moved all news of a cluster into another
+	EventArticleMerged = "article.merged"
+
+	// События жизненного цикла кластера пишутся в отдельный топик
+	EventClusterCreated = "cluster.created"
+	EventClusterUpdated = "cluster.updated"
+	EventClusterClosed  = "cluster.closed"
+	EventClusterMerged  = "cluster.merged"
+)
+
 type Item struct {
 	ID          int        `json:"id"`
 	Title       string     `json:"title"`
@@ -18,6 +37,31 @@ type Item struct {
 	Changed     bool       `json:"changed"`
 }
 
+// News converts parsed feed item to news for clustering.
+// Missing PubDate is replaced with the current time.
+func (item Item) News() News {
+	date := time.Now().UTC()
+	if item.PubDate != nil {
+		date = *item.PubDate
+	}
+	return News{
+		MD5:         item.MD5,
+		ID:          int64(item.ID),
+		ClusterID:   0,
+		Title:       item.Title,
+		Description: item.Description,
+		FullText:    item.FullText,
+		Enclosure:   item.Enclosure,
+		PublishDate: date.Format(time.RFC3339),
+		Embedding:   make([]float64, 0, 256),
+		IsRT:        false,
+		SourceName:  item.Name,
+		URL:         item.Link,
+		Changed:     item.Changed,
+		Trace:       make(map[string]string),
+	}
+}
+
 type News struct {
 	MD5         string    `json:"-"`
 	ID          int64     `json:"id"`
@@ -31,11 +75,101 @@ type News struct {
 	IsRT        bool      `json:"is_rt"`
 	SourceName  string    `json:"source_name"`
 	URL         string    `json:"url"`
+	IsDuplicate bool      `json:"is_duplicate"`
+	DuplicateOf int64     `json:"duplicate_of,omitempty"`
+	Changed     bool      `json:"-"`
+	Decision    *Decision `json:"decision,omitempty"`
+	// Trace хранит заголовки трассировки входящего сообщения
+	Trace map[string]string `json:"-"`
+	// Ack подтверждает входящее сообщение после обработки, nil если источник не требует подтверждения
+	Ack func() `json:"-"`
+}
+
+const (
+	OutcomeJoined    = "joined"
+	OutcomeNew       = "new"
+	OutcomeDuplicate = "duplicate"
+)
+
+// Decision explains why the news was assigned to its cluster
+type Decision struct {
+	Outcome       string     `json:"outcome"`
+	Strategy      string     `json:"strategy"`
+	ConfigVersion string     `json:"config_version"`
+	Distance      float64    `json:"distance"`
+	Similarity    float64    `json:"similarity"`
+	Threshold     float64    `json:"threshold"`
+	ClusterSize   int64      `json:"cluster_size"`
+	RunnerUp      *Candidate `json:"runner_up,omitempty"`
+}
+
+// EditEvent is published when an editor changes clusters manually
+type EditEvent struct {
+	Event             string    `json:"event"`
+	FeedID            int64     `json:"feed_id,omitempty"`
+	ClusterID         int64     `json:"cluster_id"`
+	PreviousClusterID int64     `json:"previous_cluster_id,omitempty"`
+	Moved             []int64   `json:"moved,omitempty"`
+	Pinned            *bool     `json:"pinned,omitempty"`
+	Actor             string    `json:"actor"`
+	Time              time.Time `json:"time"`
+}
+
+const (
+	CloseReasonMerged = "merged"
+)
+
+// ClusterEvent describes a change of cluster membership on the cluster events topic.
+// FeedID is the news which joined or left the cluster, MergedFrom is the cluster
+// merged into ClusterID, Reason explains why the cluster was closed.
+type ClusterEvent struct {
+	Event            string    `json:"event"`
+	ClusterID        int64     `json:"cluster_id"`
+	FeedID           int64     `json:"feed_id,omitempty"`
+	MergedFrom       int64     `json:"merged_from,omitempty"`
+	Size             int64     `json:"size"`
+	IsRT             bool      `json:"is_rt"`
+	Centroid         []float64 `json:"centroid,omitempty"`
+	RepresentativeID int64     `json:"representative_id,omitempty"`
+	Headline         string    `json:"headline,omitempty"`
+	Summary          string    `json:"summary,omitempty"`
+	Reason           string    `json:"reason,omitempty"`
+	Actor            string    `json:"actor,omitempty"`
+	Time             time.Time `json:"time"`
+}
+
+// Candidate is a cluster considered when assigning news
+type Candidate struct {
+	ClusterID           int64   `json:"cluster_id"`
+	NewsCount           int64   `json:"news_count"`
+	Distance            float64 `json:"distance"`
+	Score               float64 `json:"score"`
+	Threshold           float64 `json:"threshold"`
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	Vetoed              bool    `json:"vetoed,omitempty"`
+	Matched             bool    `json:"matched"`
+}
+
+// Classification is the result of a dry-run assignment
+type Classification struct {
+	Cluster    string      `json:"cluster"` // id кластера или "new"
+	ClusterID  int64       `json:"cluster_id,omitempty"`
+	IsRT       bool        `json:"is_rt"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+// ClassifiedNews is the dry-run result for the news
+type ClassifiedNews struct {
+	NewsID int64 `json:"news_id"`
+	Classification
 }
 
 type Cluster struct {
-	ID        int64   `json:"cluster_id"`
-	NewsCount int64   `json:"news_count"`
-	Distance  float64 `json:"distance"`
-	IsRT      bool    `json:"is_rt"`
+	ID          int64   `json:"cluster_id"`
+	NewsCount   int64   `json:"news_count"`
+	Distance    float64 `json:"distance"`
+	IsRT        bool    `json:"is_rt"`
+	Title       string  `json:"title,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Text        string  `json:"text,omitempty"`
 }
@@ -1,23 +1,155 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"agregator/group/internal/endpoint/admin"
 	"agregator/group/internal/endpoint/app"
 	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/db/memory"
 	"agregator/group/internal/service/rtchecker"
+	"agregator/group/internal/service/tracing"
+	"agregator/group/internal/service/transport"
 )
 
 type App struct {
 	endpoint *app.App
+	admin    *admin.Server
+	logger   interfaces.Logger
+	shutdown func(context.Context) error
 }
 
-func New(diff, maxDistance, alpha float64, sleepTime time.Duration, logger interfaces.Logger) *App {
-	return &App{
-		endpoint: app.New(diff, maxDistance, alpha, sleepTime, rtchecker.New(logger), logger),
+// Options of a group maker run
+type Options struct {
+	// Workers is the number of news processed concurrently, 0 reads WORKERS
+	Workers int
+	// Admin starts the admin server with health checks, metrics and the REST API
+	Admin bool
+	// MemoryOnly refuses storage other than memory. The outbox of a shared database
+	// is relayed by every instance, so news written there would reach the service topics.
+	MemoryOnly bool
+}
+
+// ErrSharedStorage is returned when MemoryOnly is set and STORAGE is not memory
+var ErrSharedStorage = errors.New("STORAGE must be memory")
+
+// New creates the service with input and output transports selected by SOURCE and SINK
+func New(diff, maxDistance, alpha float64, sleepTime time.Duration, logger interfaces.Logger) (*App, error) {
+	source, sink, clusters, err := transport.New(logger)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithTransport(source, sink, clusters, diff, maxDistance, alpha, sleepTime, Options{Admin: true}, logger)
+}
+
+// NewWithTransport creates the service reading from source and writing to sink,
+// cluster events are written to clusters unless it is nil. Run returns when the source ends.
+func NewWithTransport(source interfaces.Source, sink, clusters interfaces.Sink, diff, maxDistance, alpha float64, sleepTime time.Duration, options Options, logger interfaces.Logger) (*App, error) {
+	if options.MemoryOnly && os.Getenv("STORAGE") != "memory" {
+		return nil, ErrSharedStorage
+	}
+	shutdown, err := tracing.Init(context.Background(), logger)
+	if err != nil {
+		return nil, fmt.Errorf("initializing tracing: %w", err)
+	}
+	store, err := newStore(logger)
+	if err != nil {
+		return nil, err
+	}
+	checker, err := rtchecker.New(store, logger)
+	if err != nil {
+		return nil, fmt.Errorf("creating RT checker: %w", err)
+	}
+	endpoint, err := app.New(store, source, sink, clusters, diff, maxDistance, alpha, sleepTime, checker, logger)
+	if err != nil {
+		return nil, err
+	}
+	if options.Workers > 0 {
+		endpoint.SetWorkers(options.Workers)
+	}
+	a := &App{
+		endpoint: endpoint,
+		logger:   logger,
+		shutdown: shutdown,
+	}
+	if options.Admin {
+		a.admin = admin.New(adminAddr(), logger)
+		endpoint.RegisterChecks(a.admin)
+		endpoint.RegisterAPI(a.admin)
+	}
+	return a, nil
+}
+
+// newStore creates storage selected by STORAGE: postgres (default) or memory.
+// The in-memory store is lost on restart and is meant for demos and local runs,
+// RT_WORDS is a comma separated list of RT words for it.
+func newStore(logger interfaces.Logger) (db.Store, error) {
+	switch os.Getenv("STORAGE") {
+	case "", "postgres":
+	case "memory":
+		logger.Warn("Using in-memory storage, data is lost on restart")
+		store := memory.New()
+		if words := os.Getenv("RT_WORDS"); words != "" {
+			store.SetRTWords(strings.Split(words, ","))
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE %q", os.Getenv("STORAGE"))
+	}
+	// Миграции применяются до создания сервисов, которые читают таблицы при старте
+	if os.Getenv("AUTO_MIGRATE") == "true" {
+		err := migrate(logger)
+		if err != nil {
+			return nil, fmt.Errorf("migrating db: %w", err)
+		}
+	}
+	store, err := db.New(30, logger)
+	if err != nil {
+		return nil, fmt.Errorf("creating db: %w", err)
+	}
+	return store, nil
+}
+
+func migrate(logger interfaces.Logger) error {
+	conn, err := db.New(2, logger)
+	if err != nil {
+		return err
 	}
+	defer conn.Close()
+	return conn.MigrateUp(context.Background())
+}
+
+func adminAddr() string {
+	addr := os.Getenv("ADMIN_ADDR")
+	if addr == "" {
+		addr = os.Getenv("METRICS_ADDR")
+	}
+	if addr == "" {
+		addr = ":9090"
+	}
+	return addr
+}
+
+// ClassifyOnly switches to dry-run: news are classified without writing clusters
+func (a *App) ClassifyOnly() {
+	a.endpoint.ClassifyOnly()
 }
 
 func (a *App) Run() {
+	defer a.shutdown(context.Background())
+	if a.admin != nil {
+		go func() {
+			err := a.admin.Run()
+			if err != nil {
+				a.logger.Error("Admin server stopped", "error", err)
+			}
+		}()
+	}
 	a.endpoint.Run()
 }
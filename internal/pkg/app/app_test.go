@@ -0,0 +1,40 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	bus "agregator/group/internal/service/kafka/memory"
+)
+
+func TestMemoryOnly(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	options := Options{Workers: 1, MemoryOnly: true}
+	input, output := bus.New(0), bus.New(0)
+
+	for _, storage := range []string{"", "postgres"} {
+		t.Setenv("STORAGE", storage)
+		_, err := NewWithTransport(input, output, output, 0.1, 0.2, 0.5, time.Second, options, logger)
+		if !errors.Is(err, ErrSharedStorage) {
+			t.Errorf("STORAGE=%q: err = %v, want ErrSharedStorage", storage, err)
+		}
+	}
+
+	t.Setenv("STORAGE", "memory")
+	replay, err := NewWithTransport(input, output, output, 0.1, 0.2, 0.5, time.Second, options, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.admin != nil {
+		t.Error("admin server is created without Options.Admin")
+	}
+
+	input.Close()
+	replay.Run()
+	if len(output.Messages()) != 0 {
+		t.Errorf("empty replay wrote %d messages", len(output.Messages()))
+	}
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultMaxValue = 512
+
+// New returns structured logger configured by environment:
+// LOG_LEVEL (debug, info, warn, error), LOG_FORMAT (json, text),
+// LOG_DEBUG_SAMPLE (write every Nth debug record) and LOG_MAX_VALUE (max length of string values).
+func New() *slog.Logger {
+	return NewWithWriter(os.Stdout)
+}
+
+func NewWithWriter(w io.Writer) *slog.Logger {
+	maxValue, err := strconv.Atoi(os.Getenv("LOG_MAX_VALUE"))
+	if err != nil || maxValue <= 0 {
+		maxValue = defaultMaxValue
+	}
+	options := &slog.HandlerOptions{
+		Level: level(os.Getenv("LOG_LEVEL")),
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			return truncateAttr(a, maxValue)
+		},
+	}
+
+	var handler slog.Handler
+	if strings.ToLower(os.Getenv("LOG_FORMAT")) == "text" {
+		handler = slog.NewTextHandler(w, options)
+	} else {
+		handler = slog.NewJSONHandler(w, options)
+	}
+
+	sample, err := strconv.Atoi(os.Getenv("LOG_DEBUG_SAMPLE"))
+	if err == nil && sample > 1 {
+		handler = &samplingHandler{Handler: handler, every: uint64(sample), counter: new(atomic.Uint64)}
+	}
+	return slog.New(handler)
+}
+
+func level(name string) slog.Level {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// Truncate cuts s to max runes marking the cut
+func Truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max]) + fmt.Sprintf("…(%d more)", len(runes)-max)
+}
+
+func truncateAttr(a slog.Attr, max int) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Truncate(a.Value.String(), max))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case []float64:
+			// Эмбеддинги не пишем в лог целиком
+			a.Value = slog.StringValue(fmt.Sprintf("[%d floats]", len(v)))
+		case []byte:
+			a.Value = slog.StringValue(Truncate(string(v), max))
+		}
+	}
+	return a
+}
+
+// samplingHandler passes only every Nth debug record, other levels are not sampled
+type samplingHandler struct {
+	slog.Handler
+	every   uint64
+	counter *atomic.Uint64
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level == slog.LevelDebug && h.counter.Add(1)%h.every != 0 {
+		return nil
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithAttrs(attrs), every: h.every, counter: h.counter}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{Handler: h.Handler.WithGroup(name), every: h.every, counter: h.counter}
+}
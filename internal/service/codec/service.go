@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/service/newspb"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Sink encodes news events in the configured format and sets the content-type header.
+// Other events, cluster events among them, are written as JSON.
+type Sink struct {
+	sink   interfaces.BatchSink
+	format string
+}
+
+var _ interfaces.BatchSink = (*Sink)(nil)
+
+func NewSink(sink interfaces.BatchSink, format string) (*Sink, error) {
+	switch format {
+	case "":
+		format = FormatJSON
+	case FormatJSON, FormatProtobuf:
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	return &Sink{sink: sink, format: format}, nil
+}
+
+func (s *Sink) Ping(ctx context.Context) error {
+	return s.sink.Ping(ctx)
+}
+
+func (s *Sink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) error {
+	message, headers, err := s.encode(event, message, headers)
+	if err != nil {
+		return err
+	}
+	return s.sink.WriteMessage(ctx, event, key, message, headers)
+}
+
+func (s *Sink) WriteMessages(ctx context.Context, messages []interfaces.Message) error {
+	encoded := make([]interfaces.Message, len(messages))
+	for i, message := range messages {
+		var err error
+		message.Value, message.Headers, err = s.encode(message.Event, message.Value, message.Headers)
+		if err != nil {
+			return err
+		}
+		encoded[i] = message
+	}
+	return s.sink.WriteMessages(ctx, encoded)
+}
+
+func (s *Sink) encode(event string, message []byte, headers map[string]string) ([]byte, map[string]string, error) {
+	contentType := newspb.ContentTypeJSON
+	if s.format == FormatProtobuf && (event == model.EventArticleCreated || event == model.EventArticleUpdated) {
+		var news newspb.News
+		if err := json.Unmarshal(message, &news); err != nil {
+			return nil, nil, fmt.Errorf("encoding %s: %w", event, err)
+		}
+		message = newspb.Marshal(news)
+		contentType = newspb.ContentTypeProtobuf
+	}
+	extended := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		extended[name] = value
+	}
+	extended[newspb.ContentTypeHeader] = contentType
+	return message, extended, nil
+}
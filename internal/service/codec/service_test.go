@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	model "agregator/group/internal/model/kafka"
+	bus "agregator/group/internal/service/kafka/memory"
+	"agregator/group/service/newspb"
+)
+
+// populated fails the test if an exported JSON field of value is zero, so news
+// fields added later have to be filled in the round trip test
+func populated(t *testing.T, value reflect.Value) {
+	t.Helper()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() || strings.Split(field.Tag.Get("json"), ",")[0] == "-" {
+			continue
+		}
+		if value.Field(i).IsZero() {
+			t.Fatalf("%s.%s is not set", value.Type().Name(), field.Name)
+		}
+		if field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct {
+			populated(t, value.Field(i).Elem())
+		}
+	}
+}
+
+func TestProtobufRoundTrip(t *testing.T) {
+	news := model.News{
+		ID:          42,
+		ClusterID:   7,
+		Title:       "Заголовок",
+		Description: "description",
+		FullText:    "text",
+		Enclosure:   "https://example.com/1.jpg",
+		// Значения точно представимы в float32
+		Embedding:   []float64{0.5, -1.25, 3},
+		PublishDate: "2024-01-02T03:04:05Z",
+		IsRT:        true,
+		SourceName:  "source",
+		URL:         "https://example.com/1",
+		IsDuplicate: true,
+		DuplicateOf: 41,
+		Decision: &model.Decision{
+			Outcome:       model.OutcomeDuplicate,
+			Strategy:      "dynamic",
+			ConfigVersion: "abc",
+			Distance:      0.12,
+			Similarity:    0.88,
+			Threshold:     0.2,
+			ClusterSize:   3,
+			RunnerUp: &model.Candidate{
+				ClusterID:           9,
+				NewsCount:           4,
+				Distance:            0.4,
+				Score:               0.35,
+				Threshold:           0.25,
+				SimilarityThreshold: 0.75,
+				Vetoed:              true,
+				Matched:             true,
+			},
+		},
+	}
+	populated(t, reflect.ValueOf(news))
+
+	output := bus.New(0)
+	sink, err := NewSink(output, FormatProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(news)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteMessage(context.Background(), model.EventArticleCreated, []byte("key"), data, nil); err != nil {
+		t.Fatal(err)
+	}
+	message := output.Messages()[0]
+	contentType := message.Headers[newspb.ContentTypeHeader]
+	if contentType != newspb.ContentTypeProtobuf {
+		t.Fatalf("content type = %q", contentType)
+	}
+	decoded, err := newspb.Decode(contentType, message.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Декодированное сообщение должно совпадать с исходной новостью по всем полям JSON
+	data, err = json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result model.News
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, news) {
+		t.Errorf("decoded %+v\nwant %+v", result, news)
+	}
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/service/vector"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Cluster is founded by FeedID and represented by RepresentativeID,
+// which is the founder until another member is chosen
+type Cluster struct {
+	ID               uint64    `db:"id" json:"id"`
+	Time             time.Time `db:"time" json:"time"`
+	FeedID           int64     `db:"feed_id" json:"feed_id"`
+	IsRT             bool      `db:"is_rt" json:"is_rt"`
+	NewsCount        int64     `db:"news_count" json:"news_count"`
+	RepresentativeID int64     `db:"representative_id" json:"representative_id"`
+	Headline         string    `db:"headline" json:"headline"`
+	Summary          string    `db:"summary" json:"summary"`
+}
+
+type Member struct {
+	FeedID      int64          `db:"feed_id" json:"feed_id"`
+	Title       sql.NullString `db:"title" json:"-"`
+	Description sql.NullString `db:"description" json:"-"`
+	FullText    sql.NullString `db:"full_text" json:"-"`
+	Link        sql.NullString `db:"link" json:"-"`
+	Embedding   sql.NullString `db:"embedding" json:"-"`
+	DuplicateOf sql.NullInt64  `db:"duplicate_of" json:"-"`
+}
+
+type Assignment struct {
+	FeedID     int64             `json:"feed_id"`
+	GroupID    uint64            `json:"cluster_id"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Candidates []model.Candidate `json:"candidates"`
+	Decision   *model.Decision   `json:"decision,omitempty"`
+	// DuplicateOf is the original of a near-duplicate
+	DuplicateOf int64 `json:"duplicate_of,omitempty"`
+}
+
+type ClusterFilter struct {
+	Since  *time.Time
+	IsRT   *bool
+	Limit  int
+	Offset int
+}
+
+func (g *DB) InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error {
+	return insertAssignment(ctx, g.conn, feedID, groupID, candidates, decision)
+}
+
+func (g *DB) ListClusters(ctx context.Context, filter ClusterFilter) ([]Cluster, error) {
+	clusters := []Cluster{}
+	query := `
+        SELECT g.id, g.time, g.feed_id, g.is_rt, count(c.feed_id) AS news_count,
+               COALESCE(g.representative_id, g.feed_id) AS representative_id,
+               COALESCE(g.headline, f.title, '') AS headline,
+               COALESCE(g.summary, '') AS summary
+        FROM groups g
+        LEFT JOIN compares c ON c.group_id = g.id
+        LEFT JOIN feed f ON f.id = g.feed_id
+        WHERE ($1::timestamptz IS NULL OR g.time >= $1)
+          AND ($2::boolean IS NULL OR g.is_rt = $2)
+        GROUP BY g.id, f.id
+        ORDER BY g.time DESC, g.id DESC
+        LIMIT $3 OFFSET $4
+    `
+	err := g.conn.SelectContext(ctx, &clusters, query, filter.Since, filter.IsRT, filter.Limit, filter.Offset)
+	return clusters, err
+}
+
+// GetCluster returns sql.ErrNoRows if there is no such cluster
+func (g *DB) GetCluster(ctx context.Context, id uint64) (Cluster, error) {
+	return getCluster(ctx, g.conn, id)
+}
+
+func getCluster(ctx context.Context, q sqlx.QueryerContext, id uint64) (Cluster, error) {
+	var cluster Cluster
+	query := `
+        SELECT g.id, g.time, g.feed_id, g.is_rt, count(c.feed_id) AS news_count,
+               COALESCE(g.representative_id, g.feed_id) AS representative_id,
+               COALESCE(g.headline, f.title, '') AS headline,
+               COALESCE(g.summary, '') AS summary
+        FROM groups g
+        LEFT JOIN compares c ON c.group_id = g.id
+        LEFT JOIN feed f ON f.id = g.feed_id
+        WHERE g.id = $1
+        GROUP BY g.id, f.id
+    `
+	err := sqlx.GetContext(ctx, q, &cluster, query, id)
+	return cluster, err
+}
+
+// GetCentroid returns embedding stored for the cluster
+func (g *DB) GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error) {
+	return getCentroid(ctx, g.conn, id)
+}
+
+func getCentroid(ctx context.Context, q sqlx.QueryerContext, id uint64) (*vector.Vector, error) {
+	var embedding sql.NullString
+	err := sqlx.GetContext(ctx, q, &embedding, `SELECT embedding::text FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if !embedding.Valid {
+		return nil, nil
+	}
+	return vector.FromPqString(embedding.String)
+}
+
+// GetMembers returns members of the cluster ordered by id. With positive limit only
+// the limit most recent members, the founder and the representative are returned.
+func (g *DB) GetMembers(ctx context.Context, id uint64, limit int) ([]Member, error) {
+	return getMembers(ctx, g.conn, id, limit)
+}
+
+func getMembers(ctx context.Context, q sqlx.QueryerContext, id uint64, limit int) ([]Member, error) {
+	members := []Member{}
+	query := `
+        SELECT c.feed_id, f.title, f.description, f.full_text, f.link, c.embedding::text AS embedding, c.duplicate_of
+        FROM compares c
+        LEFT JOIN feed f ON f.id = c.feed_id
+        WHERE c.group_id = $1
+        ORDER BY c.feed_id
+    `
+	args := []any{id}
+	if limit > 0 {
+		query = `
+        SELECT c.feed_id, f.title, f.description, f.full_text, f.link, c.embedding::text AS embedding, c.duplicate_of
+        FROM compares c
+        LEFT JOIN feed f ON f.id = c.feed_id
+        WHERE c.group_id = $1 AND (
+            c.feed_id IN (SELECT feed_id FROM compares WHERE group_id = $1 ORDER BY feed_id DESC LIMIT $2)
+            OR c.feed_id IN (SELECT feed_id FROM groups WHERE id = $1)
+            OR c.feed_id IN (SELECT representative_id FROM groups WHERE id = $1)
+        )
+        ORDER BY c.feed_id
+    `
+		args = append(args, limit)
+	}
+	err := sqlx.SelectContext(ctx, q, &members, query, args...)
+	return members, err
+}
+
+// SetRepresentative stores the representative news and the headline of the cluster
+func (g *DB) SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error {
+	return setRepresentative(ctx, g.conn, id, feedID, headline)
+}
+
+func setRepresentative(ctx context.Context, q sqlx.ExecerContext, id uint64, feedID int64, headline string) error {
+	_, err := q.ExecContext(ctx, `UPDATE groups SET representative_id = $1, headline = $2 WHERE id = $3`, feedID, headline, id)
+	return err
+}
+
+// SetSummary stores the extractive summary of the cluster and returns its version,
+// which grows with every change of the summary.
+// Returns sql.ErrNoRows if the cluster does not exist.
+func (t *postgresTx) SetSummary(ctx context.Context, id uint64, summary string) (int64, error) {
+	var version int64
+	query := `UPDATE groups SET summary = $1, summary_version = summary_version + 1 WHERE id = $2 RETURNING summary_version`
+	err := t.tx.GetContext(ctx, &version, query, summary, id)
+	return version, err
+}
+
+// GetAssignment returns sql.ErrNoRows if the news was not assigned
+func (g *DB) GetAssignment(ctx context.Context, feedID uint64) (Assignment, error) {
+	var row struct {
+		FeedID      int64         `db:"feed_id"`
+		GroupID     uint64        `db:"group_id"`
+		CreatedAt   time.Time     `db:"created_at"`
+		Candidates  []byte        `db:"candidates"`
+		Decision    []byte        `db:"decision"`
+		DuplicateOf sql.NullInt64 `db:"duplicate_of"`
+	}
+	query := `
+        SELECT a.feed_id, a.group_id, a.created_at, a.candidates, a.decision, c.duplicate_of 
+        FROM assignments a
+        LEFT JOIN compares c ON c.feed_id = a.feed_id
+        WHERE a.feed_id = $1
+    `
+	err := g.conn.GetContext(ctx, &row, query, feedID)
+	if err != nil {
+		return Assignment{}, err
+	}
+	assignment := Assignment{
+		FeedID:      row.FeedID,
+		GroupID:     row.GroupID,
+		CreatedAt:   row.CreatedAt,
+		DuplicateOf: row.DuplicateOf.Int64,
+	}
+	err = json.Unmarshal(row.Candidates, &assignment.Candidates)
+	if err != nil {
+		return Assignment{}, err
+	}
+	if len(row.Decision) > 0 {
+		err = json.Unmarshal(row.Decision, &assignment.Decision)
+	}
+	return assignment, err
+}
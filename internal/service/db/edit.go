@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrNotClustered = errors.New("news is not clustered")
+	ErrFounder      = errors.New("news founded its cluster and can't leave it")
+	ErrSameCluster  = errors.New("source and target clusters are the same")
+)
+
+// ClusterDocument is what the search index knows about a cluster
+type ClusterDocument struct {
+	ID          uint64         `db:"id"`
+	Time        time.Time      `db:"time"`
+	Title       sql.NullString `db:"title"`
+	Description sql.NullString `db:"description"`
+	FullText    sql.NullString `db:"full_text"`
+	Embedding   sql.NullString `db:"embedding"`
+}
+
+// GetClusterDocument returns cluster centroid together with the headline
+// and the text of its representative news
+func (g *DB) GetClusterDocument(ctx context.Context, id uint64) (ClusterDocument, error) {
+	return getClusterDocument(ctx, g.conn, id)
+}
+
+func getClusterDocument(ctx context.Context, q sqlx.QueryerContext, id uint64) (ClusterDocument, error) {
+	var doc ClusterDocument
+	query := `
+        SELECT g.id, g.time, COALESCE(g.headline, f.title) AS title, f.description, f.full_text, g.embedding::text AS embedding
+        FROM groups g
+        LEFT JOIN feed f ON f.id = COALESCE(g.representative_id, g.feed_id)
+        WHERE g.id = $1
+    `
+	err := sqlx.GetContext(ctx, q, &doc, query, id)
+	return doc, err
+}
+
+// audit records the editor change and returns its id
+func audit(ctx context.Context, tx *sqlx.Tx, actor, action string, payload any) (int64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	query := `
+        INSERT INTO audit_log (actor, action, payload) 
+        VALUES ($1, $2, $3)
+        RETURNING id
+    `
+	var id int64
+	err = tx.GetContext(ctx, &id, query, actor, action, data)
+	return id, err
+}
+
+func groupExists(ctx context.Context, tx *sqlx.Tx, id uint64) error {
+	var found uint64
+	return tx.GetContext(ctx, &found, `SELECT id FROM groups WHERE id = $1 FOR UPDATE`, id)
+}
+
+func reassign(ctx context.Context, tx *sqlx.Tx, feedID, groupID uint64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE compares SET group_id = $1 WHERE feed_id = $2`, groupID, feedID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE assignments SET group_id = $1 WHERE feed_id = $2`, groupID, feedID)
+	return err
+}
+
+// Edit is the result of an editor change
+type Edit struct {
+	// AuditID identifies the change in the audit log
+	AuditID int64
+	// Previous is the cluster the news left, Cluster is its cluster after the change
+	Previous uint64
+	Cluster  uint64
+	// Moved are the news of the merged cluster
+	Moved []int64
+}
+
+// MoveNews moves the news to target cluster. Returns sql.ErrNoRows if target cluster does not exist.
+func (t *postgresTx) MoveNews(ctx context.Context, feedID, target uint64, actor string) (Edit, error) {
+	membership, err := getMembership(ctx, t.tx, feedID, true)
+	if err != nil {
+		return Edit{}, err
+	}
+	if membership.GroupID == 0 {
+		return Edit{}, ErrNotClustered
+	}
+	if membership.GroupID == target {
+		return Edit{}, ErrSameCluster
+	}
+	if membership.Founder {
+		return Edit{}, ErrFounder
+	}
+	err = groupExists(ctx, t.tx, target)
+	if err != nil {
+		return Edit{}, err
+	}
+	edit := Edit{Previous: membership.GroupID, Cluster: target}
+	err = reassign(ctx, t.tx, feedID, target)
+	if err != nil {
+		return Edit{}, err
+	}
+	for _, id := range []uint64{edit.Previous, target} {
+		_, err = updateCentroid(ctx, t.tx, id)
+		if err != nil {
+			return Edit{}, err
+		}
+	}
+	edit.AuditID, err = audit(ctx, t.tx, actor, "move", map[string]uint64{"feed_id": feedID, "from": edit.Previous, "to": target})
+	return edit, err
+}
+
+// DetachNews moves the news to a new cluster founded by it
+func (t *postgresTx) DetachNews(ctx context.Context, feedID uint64, actor string) (Edit, error) {
+	membership, err := getMembership(ctx, t.tx, feedID, true)
+	if err != nil {
+		return Edit{}, err
+	}
+	if membership.GroupID == 0 {
+		return Edit{}, ErrNotClustered
+	}
+	if membership.Founder {
+		return Edit{}, ErrFounder
+	}
+	edit := Edit{Previous: membership.GroupID}
+	query := `
+        INSERT INTO groups (time, feed_id, is_rt, embedding, embedding_sum, embedding_count)
+        SELECT now(), c.feed_id, g.is_rt, c.embedding, c.embedding, CASE WHEN c.embedding IS NULL THEN 0 ELSE 1 END
+        FROM compares c
+        JOIN groups g ON g.id = c.group_id
+        WHERE c.feed_id = $1
+        RETURNING id
+    `
+	err = t.tx.GetContext(ctx, &edit.Cluster, query, feedID)
+	if err != nil {
+		return Edit{}, err
+	}
+	err = reassign(ctx, t.tx, feedID, edit.Cluster)
+	if err != nil {
+		return Edit{}, err
+	}
+	_, err = updateCentroid(ctx, t.tx, edit.Previous)
+	if err != nil {
+		return Edit{}, err
+	}
+	edit.AuditID, err = audit(ctx, t.tx, actor, "detach", map[string]uint64{"feed_id": feedID, "from": edit.Previous, "to": edit.Cluster})
+	return edit, err
+}
+
+// MergeClusters moves all members of source into target and deletes source.
+// Returns sql.ErrNoRows if any cluster does not exist.
+func (t *postgresTx) MergeClusters(ctx context.Context, source, target uint64, actor string) (Edit, error) {
+	if source == target {
+		return Edit{}, ErrSameCluster
+	}
+	for _, id := range []uint64{source, target} {
+		err := groupExists(ctx, t.tx, id)
+		if err != nil {
+			return Edit{}, err
+		}
+	}
+	edit := Edit{Previous: source, Cluster: target, Moved: []int64{}}
+	err := t.tx.SelectContext(ctx, &edit.Moved, `UPDATE compares SET group_id = $1 WHERE group_id = $2 RETURNING feed_id`, target, source)
+	if err != nil {
+		return Edit{}, err
+	}
+	sort.Slice(edit.Moved, func(i, j int) bool { return edit.Moved[i] < edit.Moved[j] })
+	_, err = t.tx.ExecContext(ctx, `UPDATE assignments SET group_id = $1 WHERE group_id = $2`, target, source)
+	if err != nil {
+		return Edit{}, err
+	}
+	_, err = t.tx.ExecContext(ctx, `DELETE FROM groups WHERE id = $1`, source)
+	if err != nil {
+		return Edit{}, err
+	}
+	_, err = updateCentroid(ctx, t.tx, target)
+	if err != nil {
+		return Edit{}, err
+	}
+	edit.AuditID, err = audit(ctx, t.tx, actor, "merge", map[string]any{"source": source, "target": target, "moved": edit.Moved})
+	return edit, err
+}
+
+// SetPinned pins the news in its cluster so automated re-clustering never moves it
+func (t *postgresTx) SetPinned(ctx context.Context, feedID uint64, pinned bool, actor string) (Edit, error) {
+	var edit Edit
+	err := t.tx.GetContext(ctx, &edit.Cluster, `UPDATE compares SET pinned = $1 WHERE feed_id = $2 RETURNING group_id`, pinned, feedID)
+	if err == sql.ErrNoRows {
+		return Edit{}, ErrNotClustered
+	}
+	if err != nil {
+		return Edit{}, err
+	}
+	edit.AuditID, err = audit(ctx, t.tx, actor, "pin", map[string]any{"feed_id": feedID, "pinned": pinned})
+	return edit, err
+}
@@ -0,0 +1,575 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/db"
+	"agregator/group/service/vector"
+)
+
+var ErrDuplicate = errors.New("duplicate key")
+
+// outboxLease is how long an entry taken by the relay stays hidden from other relays
+const outboxLease = time.Minute
+
+type group struct {
+	id        uint64
+	time      time.Time
+	feedID    int64
+	isRT      bool
+	embedding []float64
+	// Нули означают основателя кластера и его заголовок
+	representativeID int64
+	headline         string
+	summary          string
+	summaryVersion   int64
+}
+
+type member struct {
+	groupID     uint64
+	embedding   []float64
+	pinned      bool
+	duplicateOf int64
+}
+
+// Feed is a news from the upstream feed table
+type Feed struct {
+	ID          uint64
+	Title       string
+	Description string
+	FullText    string
+	Link        string
+	Parsed      bool
+}
+
+type AuditEntry struct {
+	ID      int64
+	Actor   string
+	Action  string
+	Payload any
+	Time    time.Time
+}
+
+type outboxEntry struct {
+	db.OutboxEntry
+	nextAttempt time.Time
+}
+
+// Store is a thread-safe in-memory implementation of db.Store
+// for tests and the standalone demo mode
+type Store struct {
+	mu          sync.Mutex
+	nextGroupID uint64
+	nextOutbox  int64
+	nextAudit   int64
+	groups      map[uint64]*group
+	groupByFeed map[int64]uint64
+	compares    map[uint64]*member
+	assignments map[uint64]db.Assignment
+	feeds       map[uint64]*Feed
+	rtWords     []string
+	// outbox holds undelivered entries, outboxKeys keeps idempotency keys
+	// of all entries with the delivery time, zero while pending
+	outbox     []*outboxEntry
+	outboxKeys map[string]time.Time
+	audit      []AuditEntry
+}
+
+var _ db.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		groups:      make(map[uint64]*group),
+		groupByFeed: make(map[int64]uint64),
+		compares:    make(map[uint64]*member),
+		assignments: make(map[uint64]db.Assignment),
+		feeds:       make(map[uint64]*Feed),
+		outboxKeys:  make(map[string]time.Time),
+	}
+}
+
+// SetRTWords replaces RT words returned by GetRTWords
+func (s *Store) SetRTWords(words []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rtWords = append([]string(nil), words...)
+}
+
+// AddFeed adds or replaces a news of the feed table
+func (s *Store) AddFeed(feed Feed) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feeds[feed.ID] = &feed
+}
+
+// Feed returns a copy of the feed news
+func (s *Store) Feed(id uint64) (Feed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	feed, ok := s.feeds[id]
+	if !ok {
+		return Feed{}, false
+	}
+	return *feed, true
+}
+
+func (s *Store) Audit() []AuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEntry(nil), s.audit...)
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) CheckSchema(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) GetRTWords() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.rtWords...), nil
+}
+
+func copyVector(vec *vector.Vector) []float64 {
+	return append([]float64(nil), vec.GetArray()...)
+}
+
+func (s *Store) insertGroup(t time.Time, feedID int64, isRT bool, vec *vector.Vector) uint64 {
+	if _, ok := s.groupByFeed[feedID]; ok {
+		return 0
+	}
+	s.nextGroupID++
+	s.groups[s.nextGroupID] = &group{
+		id:        s.nextGroupID,
+		time:      t,
+		feedID:    feedID,
+		isRT:      isRT,
+		embedding: copyVector(vec),
+	}
+	s.groupByFeed[feedID] = s.nextGroupID
+	return s.nextGroupID
+}
+
+func (s *Store) deleteGroup(id uint64) {
+	if g, ok := s.groups[id]; ok {
+		delete(s.groupByFeed, g.feedID)
+		delete(s.groups, id)
+	}
+}
+
+func (s *Store) insertCompares(groupID uint64, feedID uint64, vec *vector.Vector, duplicateOf int64) error {
+	if _, ok := s.compares[feedID]; ok {
+		return fmt.Errorf("compares feed_id %d: %w", feedID, ErrDuplicate)
+	}
+	if _, ok := s.groups[groupID]; !ok {
+		return fmt.Errorf("group %d does not exist", groupID)
+	}
+	s.compares[feedID] = &member{groupID: groupID, embedding: copyVector(vec), duplicateOf: duplicateOf}
+	return nil
+}
+
+func (s *Store) insertAssignment(feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) {
+	if candidates == nil {
+		candidates = []model.Candidate{}
+	}
+	s.assignments[feedID] = db.Assignment{
+		FeedID:     int64(feedID),
+		GroupID:    groupID,
+		CreatedAt:  time.Now(),
+		Candidates: append([]model.Candidate(nil), candidates...),
+		Decision:   decision,
+	}
+}
+
+func (s *Store) updateParsed(id uint64, parsed bool) {
+	if feed, ok := s.feeds[id]; ok {
+		feed.Parsed = parsed
+	}
+}
+
+func (s *Store) updateCentroid(groupID uint64) *vector.Vector {
+	var centroid *vector.Vector
+	count := 0
+	for _, m := range s.compares {
+		if m.groupID != groupID || len(m.embedding) == 0 || m.duplicateOf != 0 {
+			continue
+		}
+		vec := vector.New(m.embedding)
+		if centroid == nil {
+			centroid = vec
+		} else {
+			centroid.Add(vec)
+		}
+		count++
+	}
+	if centroid == nil {
+		return nil
+	}
+	centroid.Divide(float64(count))
+	if g, ok := s.groups[groupID]; ok {
+		g.embedding = copyVector(centroid)
+	}
+	return centroid
+}
+
+func (s *Store) GetClusterDocument(ctx context.Context, id uint64) (db.ClusterDocument, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.document(id)
+}
+
+func (s *Store) document(id uint64) (db.ClusterDocument, error) {
+	g, ok := s.groups[id]
+	if !ok {
+		return db.ClusterDocument{}, sql.ErrNoRows
+	}
+	doc := db.ClusterDocument{ID: g.id, Time: g.time}
+	if len(g.embedding) > 0 {
+		doc.Embedding = sql.NullString{String: vector.New(g.embedding).ToPqString(), Valid: true}
+	}
+	if feed, ok := s.feeds[uint64(g.representative())]; ok {
+		doc.Title = sql.NullString{String: feed.Title, Valid: true}
+		doc.Description = sql.NullString{String: feed.Description, Valid: true}
+		doc.FullText = sql.NullString{String: feed.FullText, Valid: true}
+	}
+	if g.headline != "" {
+		doc.Title = sql.NullString{String: g.headline, Valid: true}
+	}
+	return doc, nil
+}
+
+func (s *Store) SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setRepresentative(id, feedID, headline)
+	return nil
+}
+
+func (s *Store) setRepresentative(id uint64, feedID int64, headline string) {
+	if g, ok := s.groups[id]; ok {
+		g.representativeID = feedID
+		g.headline = headline
+	}
+}
+
+func (s *Store) cluster(g *group) db.Cluster {
+	count := int64(0)
+	for _, m := range s.compares {
+		if m.groupID == g.id {
+			count++
+		}
+	}
+	cluster := db.Cluster{
+		ID:               g.id,
+		Time:             g.time,
+		FeedID:           g.feedID,
+		IsRT:             g.isRT,
+		NewsCount:        count,
+		RepresentativeID: g.representative(),
+		Headline:         g.headline,
+		Summary:          g.summary,
+	}
+	if cluster.Headline == "" {
+		if feed, ok := s.feeds[uint64(g.feedID)]; ok {
+			cluster.Headline = feed.Title
+		}
+	}
+	return cluster
+}
+
+func (g *group) representative() int64 {
+	if g.representativeID != 0 {
+		return g.representativeID
+	}
+	return g.feedID
+}
+
+func (s *Store) ListClusters(ctx context.Context, filter db.ClusterFilter) ([]db.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clusters := []db.Cluster{}
+	for _, g := range s.groups {
+		if filter.Since != nil && g.time.Before(*filter.Since) {
+			continue
+		}
+		if filter.IsRT != nil && g.isRT != *filter.IsRT {
+			continue
+		}
+		clusters = append(clusters, s.cluster(g))
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if !clusters[i].Time.Equal(clusters[j].Time) {
+			return clusters[i].Time.After(clusters[j].Time)
+		}
+		return clusters[i].ID > clusters[j].ID
+	})
+	if filter.Offset >= len(clusters) {
+		return []db.Cluster{}, nil
+	}
+	clusters = clusters[filter.Offset:]
+	if filter.Limit > 0 && len(clusters) > filter.Limit {
+		clusters = clusters[:filter.Limit]
+	}
+	return clusters, nil
+}
+
+func (s *Store) GetCluster(ctx context.Context, id uint64) (db.Cluster, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[id]
+	if !ok {
+		return db.Cluster{}, sql.ErrNoRows
+	}
+	return s.cluster(g), nil
+}
+
+func (s *Store) GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.centroid(id)
+}
+
+func (s *Store) centroid(id uint64) (*vector.Vector, error) {
+	g, ok := s.groups[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if len(g.embedding) == 0 {
+		return nil, nil
+	}
+	return vector.New(g.embedding), nil
+}
+
+func (s *Store) reassign(feedID, groupID uint64) {
+	s.compares[feedID].groupID = groupID
+	if assignment, ok := s.assignments[feedID]; ok {
+		assignment.GroupID = groupID
+		s.assignments[feedID] = assignment
+	}
+}
+
+func (s *Store) addAudit(actor, action string, payload any) int64 {
+	s.nextAudit++
+	id := s.nextAudit
+	s.audit = append(s.audit, AuditEntry{ID: id, Actor: actor, Action: action, Payload: payload, Time: time.Now()})
+	return id
+}
+
+func (s *Store) membership(feedID uint64) db.Membership {
+	m, ok := s.compares[feedID]
+	if !ok {
+		return db.Membership{}
+	}
+	founder := false
+	if g, ok := s.groups[m.groupID]; ok {
+		founder = g.feedID == int64(feedID)
+	}
+	return db.Membership{GroupID: m.groupID, Founder: founder, Pinned: m.pinned}
+}
+
+func (s *Store) GetGroupByFeed(ctx context.Context, feedID uint64) (db.Membership, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.membership(feedID), nil
+}
+
+func (s *Store) MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.moveCompare(feedID, groupID, vec)
+	return nil
+}
+
+func (s *Store) moveCompare(feedID uint64, groupID uint64, vec *vector.Vector) {
+	if _, ok := s.compares[feedID]; !ok {
+		return
+	}
+	s.reassign(feedID, groupID)
+	s.compares[feedID].embedding = copyVector(vec)
+}
+
+func (s *Store) GetMembers(ctx context.Context, id uint64, limit int) ([]db.Member, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.members(id, limit), nil
+}
+
+// members returns members like the Postgres store: with positive limit only the limit
+// most recent members, the founder and the representative
+func (s *Store) members(id uint64, limit int) []db.Member {
+	ids := []uint64{}
+	for feedID, m := range s.compares {
+		if m.groupID == id {
+			ids = append(ids, feedID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if g, ok := s.groups[id]; ok && limit > 0 && len(ids) > limit {
+		keep := map[uint64]bool{uint64(g.feedID): true, uint64(g.representative()): true}
+		for _, feedID := range ids[len(ids)-limit:] {
+			keep[feedID] = true
+		}
+		recent := []uint64{}
+		for _, feedID := range ids {
+			if keep[feedID] {
+				recent = append(recent, feedID)
+			}
+		}
+		ids = recent
+	}
+	members := []db.Member{}
+	for _, feedID := range ids {
+		m := s.compares[feedID]
+		row := db.Member{FeedID: int64(feedID)}
+		if feed, ok := s.feeds[feedID]; ok {
+			row.Title = sql.NullString{String: feed.Title, Valid: true}
+			row.Description = sql.NullString{String: feed.Description, Valid: true}
+			row.FullText = sql.NullString{String: feed.FullText, Valid: true}
+			row.Link = sql.NullString{String: feed.Link, Valid: true}
+		}
+		if len(m.embedding) > 0 {
+			row.Embedding = sql.NullString{String: vector.New(m.embedding).ToPqString(), Valid: true}
+		}
+		if m.duplicateOf != 0 {
+			row.DuplicateOf = sql.NullInt64{Int64: m.duplicateOf, Valid: true}
+		}
+		members = append(members, row)
+	}
+	return members
+}
+
+func (s *Store) InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.insertAssignment(feedID, groupID, candidates, decision)
+	return nil
+}
+
+func (s *Store) GetAssignment(ctx context.Context, feedID uint64) (db.Assignment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assignment, ok := s.assignments[feedID]
+	if !ok {
+		return db.Assignment{}, sql.ErrNoRows
+	}
+	if m, ok := s.compares[feedID]; ok {
+		assignment.DuplicateOf = m.duplicateOf
+	}
+	return assignment, nil
+}
+
+func (s *Store) UpdateParsed(ctx context.Context, id uint64, parsed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateParsed(id, parsed)
+	return nil
+}
+
+func (s *Store) addOutbox(entry db.OutboxEntry) {
+	if _, ok := s.outboxKeys[entry.IdempotencyKey]; ok {
+		return
+	}
+	s.nextOutbox++
+	entry.ID = s.nextOutbox
+	s.outboxKeys[entry.IdempotencyKey] = time.Time{}
+	s.outbox = append(s.outbox, &outboxEntry{OutboxEntry: entry, nextAttempt: time.Now()})
+}
+
+// ProcessOutbox delivers pending entries like the Postgres store. Due entries are
+// leased under the lock and delivered without it, so workers are not blocked by the
+// sink and the index.
+func (s *Store) ProcessOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []db.OutboxEntry) []error) (int, error) {
+	due := s.leaseOutbox(limit)
+	if len(due) == 0 {
+		return 0, nil
+	}
+	results := deliver(ctx, due)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivered := 0
+	now := time.Now()
+	pending := s.outbox[:0]
+	for _, entry := range s.outbox {
+		i := sort.Search(len(due), func(i int) bool { return due[i].ID >= entry.ID })
+		if i == len(due) || due[i].ID != entry.ID {
+			pending = append(pending, entry)
+			continue
+		}
+		switch {
+		case results[i] == nil:
+			s.outboxKeys[entry.IdempotencyKey] = now
+			delivered++
+			continue
+		case errors.Is(results[i], db.ErrOutboxBlocked):
+			entry.nextAttempt = now
+		default:
+			entry.Attempts++
+			entry.nextAttempt = now.Add(backoff(entry.Attempts))
+			if entry.Attempts >= maxAttempts {
+				// Ключ идемпотентности остается, запись больше не доставляется
+				continue
+			}
+		}
+		pending = append(pending, entry)
+	}
+	s.outbox = pending
+	return delivered, nil
+}
+
+// leaseOutbox returns copies of up to limit due entries ordered by id and postpones
+// their next attempt, so a concurrent relay does not deliver them twice. Entries
+// behind a waiting or leased entry of the same kind and key are skipped.
+func (s *Store) leaseOutbox(limit int) []db.OutboxEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	due := []db.OutboxEntry{}
+	blocked := map[[2]string]bool{}
+	for _, entry := range s.outbox {
+		if len(due) >= limit {
+			break
+		}
+		key := [2]string{entry.Kind, entry.Key}
+		if entry.nextAttempt.After(now) {
+			blocked[key] = entry.Key != ""
+			continue
+		}
+		if blocked[key] {
+			continue
+		}
+		entry.nextAttempt = now.Add(outboxLease)
+		due = append(due, entry.OutboxEntry)
+	}
+	return due
+}
+
+// DeleteDelivered forgets idempotency keys of entries delivered before the time
+func (s *Store) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := 0
+	for key, delivered := range s.outboxKeys {
+		if !delivered.IsZero() && delivered.Before(before) {
+			delete(s.outboxKeys, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *Store) PendingOutbox(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.outbox), nil
+}
@@ -0,0 +1,266 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/db"
+	"agregator/group/service/vector"
+)
+
+// tx holds the store lock until Commit or Rollback, so transactions are serialized.
+// Changes are applied immediately and undone in reverse order on rollback.
+type tx struct {
+	store *Store
+	undo  []func()
+	done  bool
+}
+
+func (s *Store) Begin(ctx context.Context) (db.Tx, error) {
+	s.mu.Lock()
+	return &tx{store: s}, nil
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.store.mu.Unlock()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if t.done {
+		return nil
+	}
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+	t.done = true
+	t.store.mu.Unlock()
+	return nil
+}
+
+func (t *tx) InsertGroup(ctx context.Context, date time.Time, feedID int64, isRT bool, vec *vector.Vector) (uint64, error) {
+	return t.insertGroup(date, feedID, isRT, vec), nil
+}
+
+func (t *tx) insertGroup(date time.Time, feedID int64, isRT bool, vec *vector.Vector) uint64 {
+	id := t.store.insertGroup(date, feedID, isRT, vec)
+	if id != 0 {
+		t.undo = append(t.undo, func() { t.store.deleteGroup(id) })
+	}
+	return id
+}
+
+func (t *tx) deleteGroup(id uint64) {
+	if g, ok := t.store.groups[id]; ok {
+		t.undo = append(t.undo, func() {
+			t.store.groups[id] = g
+			t.store.groupByFeed[g.feedID] = id
+		})
+	}
+	t.store.deleteGroup(id)
+}
+
+func (t *tx) reassign(feedID, groupID uint64) {
+	previous := t.store.compares[feedID].groupID
+	t.undo = append(t.undo, func() { t.store.reassign(feedID, previous) })
+	t.store.reassign(feedID, groupID)
+}
+
+func (t *tx) addAudit(actor, action string, payload any) int64 {
+	count := len(t.store.audit)
+	t.undo = append(t.undo, func() { t.store.audit = t.store.audit[:count] })
+	return t.store.addAudit(actor, action, payload)
+}
+
+func (t *tx) InsertCompares(ctx context.Context, groupID uint64, feedID uint64, vec *vector.Vector, duplicateOf int64) error {
+	err := t.store.insertCompares(groupID, feedID, vec, duplicateOf)
+	if err == nil {
+		t.undo = append(t.undo, func() { delete(t.store.compares, feedID) })
+	}
+	return err
+}
+
+func (t *tx) InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error {
+	previous, existed := t.store.assignments[feedID]
+	t.store.insertAssignment(feedID, groupID, candidates, decision)
+	t.undo = append(t.undo, func() {
+		if existed {
+			t.store.assignments[feedID] = previous
+		} else {
+			delete(t.store.assignments, feedID)
+		}
+	})
+	return nil
+}
+
+func (t *tx) UpdateParsed(ctx context.Context, id uint64, parsed bool) error {
+	if feed, ok := t.store.feeds[id]; ok {
+		previous := feed.Parsed
+		t.undo = append(t.undo, func() { feed.Parsed = previous })
+	}
+	t.store.updateParsed(id, parsed)
+	return nil
+}
+
+func (t *tx) UpdateCentroid(ctx context.Context, groupID uint64) (*vector.Vector, error) {
+	return t.updateCentroid(groupID), nil
+}
+
+// JoinCentroid recalculates the centroid, transactions of the memory store are serialized
+func (t *tx) JoinCentroid(ctx context.Context, groupID uint64, vec *vector.Vector) (*vector.Vector, error) {
+	return t.updateCentroid(groupID), nil
+}
+
+func (t *tx) updateCentroid(groupID uint64) *vector.Vector {
+	if g, ok := t.store.groups[groupID]; ok {
+		previous := g.embedding
+		t.undo = append(t.undo, func() { g.embedding = previous })
+	}
+	return t.store.updateCentroid(groupID)
+}
+
+func (t *tx) GetCluster(ctx context.Context, id uint64) (db.Cluster, error) {
+	g, ok := t.store.groups[id]
+	if !ok {
+		return db.Cluster{}, sql.ErrNoRows
+	}
+	return t.store.cluster(g), nil
+}
+
+func (t *tx) GetMembers(ctx context.Context, id uint64, limit int) ([]db.Member, error) {
+	return t.store.members(id, limit), nil
+}
+
+func (t *tx) GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error) {
+	return t.store.centroid(id)
+}
+
+func (t *tx) GetClusterDocument(ctx context.Context, id uint64) (db.ClusterDocument, error) {
+	return t.store.document(id)
+}
+
+func (t *tx) MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error {
+	m, ok := t.store.compares[feedID]
+	if !ok {
+		return nil
+	}
+	previous := m.embedding
+	t.undo = append(t.undo, func() { m.embedding = previous })
+	t.reassign(feedID, groupID)
+	m.embedding = copyVector(vec)
+	return nil
+}
+
+func (t *tx) SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error {
+	if g, ok := t.store.groups[id]; ok {
+		previousID, previousHeadline := g.representativeID, g.headline
+		t.undo = append(t.undo, func() { g.representativeID, g.headline = previousID, previousHeadline })
+	}
+	t.store.setRepresentative(id, feedID, headline)
+	return nil
+}
+
+func (t *tx) AddOutbox(ctx context.Context, entry db.OutboxEntry) error {
+	count := len(t.store.outbox)
+	t.store.addOutbox(entry)
+	if len(t.store.outbox) > count {
+		t.undo = append(t.undo, func() {
+			delete(t.store.outboxKeys, entry.IdempotencyKey)
+			t.store.outbox = t.store.outbox[:count]
+		})
+	}
+	return nil
+}
+
+func (t *tx) MoveNews(ctx context.Context, feedID, target uint64, actor string) (db.Edit, error) {
+	membership := t.store.membership(feedID)
+	switch {
+	case membership.GroupID == 0:
+		return db.Edit{}, db.ErrNotClustered
+	case membership.GroupID == target:
+		return db.Edit{}, db.ErrSameCluster
+	case membership.Founder:
+		return db.Edit{}, db.ErrFounder
+	}
+	if _, ok := t.store.groups[target]; !ok {
+		return db.Edit{}, sql.ErrNoRows
+	}
+	t.reassign(feedID, target)
+	t.updateCentroid(membership.GroupID)
+	t.updateCentroid(target)
+	id := t.addAudit(actor, "move", map[string]uint64{"feed_id": feedID, "from": membership.GroupID, "to": target})
+	return db.Edit{AuditID: id, Previous: membership.GroupID, Cluster: target}, nil
+}
+
+func (t *tx) DetachNews(ctx context.Context, feedID uint64, actor string) (db.Edit, error) {
+	membership := t.store.membership(feedID)
+	if membership.GroupID == 0 {
+		return db.Edit{}, db.ErrNotClustered
+	}
+	if membership.Founder {
+		return db.Edit{}, db.ErrFounder
+	}
+	previous := t.store.groups[membership.GroupID]
+	created := t.insertGroup(time.Now(), int64(feedID), previous.isRT, vector.New(t.store.compares[feedID].embedding))
+	t.reassign(feedID, created)
+	t.updateCentroid(membership.GroupID)
+	id := t.addAudit(actor, "detach", map[string]uint64{"feed_id": feedID, "from": membership.GroupID, "to": created})
+	return db.Edit{AuditID: id, Previous: membership.GroupID, Cluster: created}, nil
+}
+
+func (t *tx) MergeClusters(ctx context.Context, source, target uint64, actor string) (db.Edit, error) {
+	if source == target {
+		return db.Edit{}, db.ErrSameCluster
+	}
+	if _, ok := t.store.groups[source]; !ok {
+		return db.Edit{}, sql.ErrNoRows
+	}
+	if _, ok := t.store.groups[target]; !ok {
+		return db.Edit{}, sql.ErrNoRows
+	}
+	moved := []int64{}
+	for feedID, m := range t.store.compares {
+		if m.groupID == source {
+			moved = append(moved, int64(feedID))
+		}
+	}
+	sort.Slice(moved, func(i, j int) bool { return moved[i] < moved[j] })
+	for _, feedID := range moved {
+		t.reassign(uint64(feedID), target)
+	}
+	t.deleteGroup(source)
+	t.updateCentroid(target)
+	id := t.addAudit(actor, "merge", map[string]any{"source": source, "target": target, "moved": moved})
+	return db.Edit{AuditID: id, Previous: source, Cluster: target, Moved: moved}, nil
+}
+
+func (t *tx) SetPinned(ctx context.Context, feedID uint64, pinned bool, actor string) (db.Edit, error) {
+	m, ok := t.store.compares[feedID]
+	if !ok {
+		return db.Edit{}, db.ErrNotClustered
+	}
+	previous := m.pinned
+	t.undo = append(t.undo, func() { m.pinned = previous })
+	m.pinned = pinned
+	id := t.addAudit(actor, "pin", map[string]any{"feed_id": feedID, "pinned": pinned})
+	return db.Edit{AuditID: id, Cluster: m.groupID}, nil
+}
+
+func (t *tx) SetSummary(ctx context.Context, id uint64, summary string) (int64, error) {
+	g, ok := t.store.groups[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	previous, version := g.summary, g.summaryVersion
+	t.undo = append(t.undo, func() { g.summary, g.summaryVersion = previous, version })
+	g.summary = summary
+	g.summaryVersion++
+	return g.summaryVersion, nil
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Произвольный ключ, чтобы одновременно запущенные экземпляры не мигрировали схему параллельно
+const migrationLock = 7301953
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads embedded files named NNNN_name.up.sql and NNNN_name.down.sql
+func loadMigrations() ([]migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+		prefix, rest, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration name %q", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q", base)
+		}
+		data, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(rest, ".up.sql"):
+			m.name = strings.TrimSuffix(rest, ".up.sql")
+			m.up = string(data)
+		case strings.HasSuffix(rest, ".down.sql"):
+			m.down = string(data)
+		default:
+			return nil, fmt.Errorf("migration %q must end with .up.sql or .down.sql", base)
+		}
+	}
+
+	result := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d must have both up and down files", m.version)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].version < result[j].version })
+	return result, nil
+}
+
+// LatestSchemaVersion returns version of the newest embedded migration
+func LatestSchemaVersion() int {
+	migrations, err := loadMigrations()
+	if err != nil || len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+func ensureMigrationsTable(ctx context.Context, q sqlx.ExecerContext) error {
+	_, err := q.ExecContext(ctx, `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version    INTEGER PRIMARY KEY,
+            applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+        )
+    `)
+	return err
+}
+
+// SchemaVersion returns the latest applied migration, 0 if none
+func (g *DB) SchemaVersion(ctx context.Context) (int, error) {
+	err := ensureMigrationsTable(ctx, g.conn)
+	if err != nil {
+		return 0, err
+	}
+	return schemaVersion(ctx, g.conn)
+}
+
+func schemaVersion(ctx context.Context, q sqlx.QueryerContext) (int, error) {
+	var version int
+	err := sqlx.GetContext(ctx, q, &version, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)
+	return version, err
+}
+
+// CheckSchema fails if the database schema is older than embedded migrations.
+// A newer schema is accepted, so pods of the previous release keep running
+// during a rolling deploy after the first new pod migrated.
+func (g *DB) CheckSchema(ctx context.Context) error {
+	version, err := g.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if version < latest {
+		return fmt.Errorf("database schema version is %d, expected %d: run \"groupmaker migrate up\" or set AUTO_MIGRATE=true", version, latest)
+	}
+	if version > latest {
+		g.logger.Warn("Database schema is newer than this release", "version", version, "expected", latest)
+	}
+	return nil
+}
+
+// MigrateUp applies all pending migrations, each in its own transaction
+func (g *DB) MigrateUp(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return g.withMigrationLock(ctx, func(conn *sqlx.Conn) error {
+		current, err := schemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if m.version <= current {
+				continue
+			}
+			g.logger.Info("Applying migration", "version", m.version, "name", m.name)
+			err := applyMigration(ctx, conn, m.up, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts last steps applied migrations
+func (g *DB) MigrateDown(ctx context.Context, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return g.withMigrationLock(ctx, func(conn *sqlx.Conn) error {
+		current, err := schemaVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if m.version > current {
+				continue
+			}
+			g.logger.Info("Reverting migration", "version", m.version, "name", m.name)
+			err := applyMigration(ctx, conn, m.down, `DELETE FROM schema_migrations WHERE version = $1`, m.version)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func applyMigration(ctx context.Context, conn *sqlx.Conn, script, record string, version int) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// withMigrationLock holds postgres advisory lock on a dedicated connection while fn runs
+func (g *DB) withMigrationLock(ctx context.Context, fn func(conn *sqlx.Conn) error) error {
+	conn, err := g.conn.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLock)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLock)
+
+	err = ensureMigrationsTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn)
+}
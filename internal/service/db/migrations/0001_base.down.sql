@@ -0,0 +1,3 @@
+-- feed and rt_words are filled upstream and are kept, up only creates them for empty databases
+DROP TABLE IF EXISTS compares;
+DROP TABLE IF EXISTS groups;
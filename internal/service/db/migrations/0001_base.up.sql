@@ -0,0 +1,33 @@
+CREATE EXTENSION IF NOT EXISTS vector;
+
+CREATE TABLE IF NOT EXISTS feed (
+    id          BIGSERIAL PRIMARY KEY,
+    title       TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    full_text   TEXT NOT NULL DEFAULT '',
+    link        TEXT NOT NULL DEFAULT '',
+    parsed      BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id        BIGSERIAL PRIMARY KEY,
+    time      TIMESTAMPTZ NOT NULL,
+    feed_id   BIGINT NOT NULL,
+    is_rt     BOOLEAN NOT NULL DEFAULT false,
+    embedding vector,
+    CONSTRAINT groups_feed_id_key UNIQUE (feed_id)
+);
+
+CREATE INDEX IF NOT EXISTS groups_time_idx ON groups (time DESC);
+
+CREATE TABLE IF NOT EXISTS compares (
+    group_id BIGINT NOT NULL REFERENCES groups (id),
+    feed_id  BIGINT NOT NULL,
+    CONSTRAINT compares_feed_id_key UNIQUE (feed_id)
+);
+
+CREATE INDEX IF NOT EXISTS compares_group_id_idx ON compares (group_id);
+
+CREATE TABLE IF NOT EXISTS rt_words (
+    word TEXT PRIMARY KEY
+);
@@ -0,0 +1 @@
+ALTER TABLE compares DROP COLUMN IF EXISTS embedding;
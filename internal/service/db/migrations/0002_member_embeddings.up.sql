@@ -0,0 +1 @@
+ALTER TABLE compares ADD COLUMN IF NOT EXISTS embedding vector;
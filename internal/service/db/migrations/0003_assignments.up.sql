@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS assignments (
+    feed_id    BIGINT PRIMARY KEY,
+    group_id   BIGINT NOT NULL,
+    candidates JSONB NOT NULL DEFAULT '[]',
+    decision   JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS assignments_group_id_idx ON assignments (group_id);
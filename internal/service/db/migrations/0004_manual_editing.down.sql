@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS audit_log;
+ALTER TABLE compares DROP COLUMN IF EXISTS pinned;
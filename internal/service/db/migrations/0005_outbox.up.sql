@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id              BIGSERIAL PRIMARY KEY,
+    kind            TEXT NOT NULL,
+    event           TEXT NOT NULL DEFAULT '',
+    key             TEXT NOT NULL DEFAULT '',
+    payload         BYTEA NOT NULL,
+    headers         JSONB NOT NULL DEFAULT '{}',
+    idempotency_key TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at    TIMESTAMPTZ,
+    CONSTRAINT outbox_idempotency_key_key UNIQUE (idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at) WHERE delivered_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE groups DROP COLUMN IF EXISTS headline;
+ALTER TABLE groups DROP COLUMN IF EXISTS representative_id;
@@ -0,0 +1,2 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS representative_id BIGINT;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS headline TEXT;
@@ -0,0 +1 @@
+ALTER TABLE groups DROP COLUMN IF EXISTS summary;
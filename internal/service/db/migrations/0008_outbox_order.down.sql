@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS outbox_delivered_idx;
+DROP INDEX IF EXISTS outbox_key_pending_idx;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS outbox_key_pending_idx ON outbox (kind, key, id) WHERE delivered_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS outbox_delivered_idx ON outbox (delivered_at) WHERE delivered_at IS NOT NULL;
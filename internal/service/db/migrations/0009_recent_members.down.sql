@@ -0,0 +1 @@
+DROP INDEX IF EXISTS compares_group_feed_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS compares_group_feed_idx ON compares (group_id, feed_id);
@@ -0,0 +1,8 @@
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS failed_at TIMESTAMPTZ;
+
+-- Отказавшие записи больше не ждут доставки и не держат очередь своего ключа
+DROP INDEX IF EXISTS outbox_key_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_key_pending_idx ON outbox (kind, key, id) WHERE delivered_at IS NULL AND failed_at IS NULL;
+DROP INDEX IF EXISTS outbox_pending_idx;
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (next_attempt_at) WHERE delivered_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_failed_idx ON outbox (failed_at) WHERE failed_at IS NOT NULL;
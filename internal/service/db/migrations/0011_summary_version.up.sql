@@ -0,0 +1 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS summary_version BIGINT NOT NULL DEFAULT 0;
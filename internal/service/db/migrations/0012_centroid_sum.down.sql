@@ -0,0 +1,2 @@
+ALTER TABLE groups DROP COLUMN IF EXISTS embedding_count;
+ALTER TABLE groups DROP COLUMN IF EXISTS embedding_sum;
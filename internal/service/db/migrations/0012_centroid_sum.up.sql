@@ -0,0 +1,13 @@
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS embedding_sum vector;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS embedding_count INTEGER NOT NULL DEFAULT 0;
+
+-- Сумма эмбеддингов участников позволяет обновлять центроид без чтения всего кластера
+UPDATE groups g
+SET embedding_sum = s.total, embedding_count = s.count
+FROM (
+    SELECT group_id, sum(embedding) AS total, count(*) AS count
+    FROM compares
+    WHERE embedding IS NOT NULL
+    GROUP BY group_id
+) s
+WHERE g.id = s.group_id;
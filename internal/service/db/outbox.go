@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	OutboxKafka = "kafka"
+	OutboxIndex = "index"
+	// OutboxClusters entries are written to the cluster events topic
+	OutboxClusters = "clusters"
+	// OutboxIndexDelete is the event of index entries which remove the cluster,
+	// other index entries register the document of the payload
+	OutboxIndexDelete = "delete"
+
+	// outboxLease is how long an entry taken by the relay stays hidden from other relays
+	outboxLease = time.Minute
+)
+
+// ErrOutboxBlocked is returned by deliver for entries which were not attempted
+// because an earlier entry of the same key failed
+var ErrOutboxBlocked = errors.New("earlier entry of the key is not delivered")
+
+// OutboxEntry is a side effect written together with the data it describes
+// and delivered later by the relay
+type OutboxEntry struct {
+	ID             int64             `db:"id"`
+	Kind           string            `db:"kind"`
+	Event          string            `db:"event"`
+	Key            string            `db:"key"`
+	Payload        []byte            `db:"payload"`
+	Headers        map[string]string `db:"-"`
+	RawHeaders     []byte            `db:"headers"`
+	IdempotencyKey string            `db:"idempotency_key"`
+	Attempts       int               `db:"attempts"`
+}
+
+// AddOutbox writes entry in the transaction. Entries with already known idempotency key are ignored.
+func (t *postgresTx) AddOutbox(ctx context.Context, entry OutboxEntry) error {
+	headers, err := json.Marshal(entry.Headers)
+	if err != nil {
+		return err
+	}
+	if entry.Headers == nil {
+		headers = []byte("{}")
+	}
+	query := `
+        INSERT INTO outbox (kind, event, key, payload, headers, idempotency_key) 
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (idempotency_key) DO NOTHING
+    `
+	_, err = t.tx.ExecContext(ctx, query, entry.Kind, entry.Event, entry.Key, entry.Payload, headers, entry.IdempotencyKey)
+	return err
+}
+
+// ProcessOutbox leases up to limit due entries in a short transaction, passes them
+// to deliver outside of it and records the results. deliver returns an error for
+// every entry, ErrOutboxBlocked releases the entry without counting an attempt.
+// An entry is not taken while an earlier entry of the same kind and key waits
+// for retry or is leased by another relay, so entries of a key are delivered in order.
+// An entry failed maxAttempts times is marked failed: it is kept for inspection,
+// is not retried and no longer holds back later entries of its key.
+// Returns the number of delivered entries.
+func (g *DB) ProcessOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []OutboxEntry) []error) (int, error) {
+	entries, err := g.leaseOutbox(ctx, limit)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+	results := make([]error, len(entries))
+	valid := make([]OutboxEntry, 0, len(entries))
+	index := make([]int, 0, len(entries))
+	for i := range entries {
+		if err := json.Unmarshal(entries[i].RawHeaders, &entries[i].Headers); err != nil {
+			results[i] = err
+			continue
+		}
+		valid = append(valid, entries[i])
+		index = append(index, i)
+	}
+	for i, err := range deliver(ctx, valid) {
+		results[index[i]] = err
+	}
+
+	delivered, released := []int64{}, []int64{}
+	for i, entry := range entries {
+		switch {
+		case results[i] == nil:
+			delivered = append(delivered, entry.ID)
+		case errors.Is(results[i], ErrOutboxBlocked):
+			released = append(released, entry.ID)
+		default:
+			_, err = g.conn.ExecContext(ctx, `
+                UPDATE outbox 
+                SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2,
+                    failed_at = CASE WHEN attempts + 1 >= $4 THEN now() END
+                WHERE id = $3
+            `, results[i].Error(), time.Now().Add(backoff(entry.Attempts+1)), entry.ID, maxAttempts)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	if len(released) > 0 {
+		_, err = g.conn.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = now() WHERE id = ANY($1)`, pq.Array(released))
+		if err != nil {
+			return 0, err
+		}
+	}
+	if len(delivered) > 0 {
+		_, err = g.conn.ExecContext(ctx, `UPDATE outbox SET delivered_at = now(), attempts = attempts + 1 WHERE id = ANY($1)`, pq.Array(delivered))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(delivered), nil
+}
+
+// leaseOutbox takes due entries ordered by id and moves their next attempt
+// forward by outboxLease, so an entry of a crashed relay is retried after the lease
+func (g *DB) leaseOutbox(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	entries := []OutboxEntry{}
+	err := g.inTx(ctx, func(tx *sqlx.Tx) error {
+		// Выдача аренды сериализуется, иначе две копии relay могут взять записи одного ключа
+		_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('outbox'))`)
+		if err != nil {
+			return err
+		}
+		query := `
+            WITH due AS (
+                SELECT o.id
+                FROM outbox o
+                WHERE o.delivered_at IS NULL AND o.failed_at IS NULL AND o.next_attempt_at <= now()
+                  AND NOT EXISTS (
+                      SELECT 1 FROM outbox e
+                      WHERE e.kind = o.kind AND e.key = o.key AND o.key <> '' AND e.id < o.id
+                        AND e.delivered_at IS NULL AND e.failed_at IS NULL AND e.next_attempt_at > now()
+                  )
+                ORDER BY o.id
+                LIMIT $1
+                FOR UPDATE SKIP LOCKED
+            )
+            UPDATE outbox
+            SET next_attempt_at = now() + $2 * interval '1 millisecond'
+            FROM due
+            WHERE outbox.id = due.id
+            RETURNING outbox.id, outbox.kind, outbox.event, outbox.key, outbox.payload,
+                      outbox.headers, outbox.idempotency_key, outbox.attempts
+        `
+		return tx.SelectContext(ctx, &entries, query, limit, outboxLease.Milliseconds())
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, err
+}
+
+// DeleteDelivered removes entries delivered before the time and returns their number
+func (g *DB) DeleteDelivered(ctx context.Context, before time.Time) (int, error) {
+	result, err := g.conn.ExecContext(ctx, `DELETE FROM outbox WHERE delivered_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	return int(deleted), err
+}
+
+// PendingOutbox returns number of undelivered entries, failed entries are not counted
+func (g *DB) PendingOutbox(ctx context.Context) (int, error) {
+	var count int
+	err := g.conn.GetContext(ctx, &count, `SELECT count(*) FROM outbox WHERE delivered_at IS NULL AND failed_at IS NULL`)
+	return count, err
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/service/vector"
+)
+
+// Groups stores clusters and their centroids
+type Groups interface {
+	GetClusterDocument(ctx context.Context, id uint64) (ClusterDocument, error)
+	ListClusters(ctx context.Context, filter ClusterFilter) ([]Cluster, error)
+	GetCluster(ctx context.Context, id uint64) (Cluster, error)
+	GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error)
+	SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error
+}
+
+// Compares stores membership of news in clusters and assignment decisions
+type Compares interface {
+	GetGroupByFeed(ctx context.Context, feedID uint64) (Membership, error)
+	MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error
+	GetMembers(ctx context.Context, id uint64, limit int) ([]Member, error)
+	InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error
+	GetAssignment(ctx context.Context, feedID uint64) (Assignment, error)
+}
+
+type Feeds interface {
+	UpdateParsed(ctx context.Context, id uint64, parsed bool) error
+}
+
+type RTWords interface {
+	GetRTWords() ([]string, error)
+}
+
+type Outbox interface {
+	ProcessOutbox(ctx context.Context, limit, maxAttempts int, backoff func(attempts int) time.Duration, deliver func(ctx context.Context, entries []OutboxEntry) []error) (int, error)
+	PendingOutbox(ctx context.Context) (int, error)
+	DeleteDelivered(ctx context.Context, before time.Time) (int, error)
+}
+
+// Tx is a unit of work for saving one news assignment, update or editor change
+// together with its outbox entries
+type Tx interface {
+	InsertGroup(ctx context.Context, date time.Time, feedID int64, isRT bool, vec *vector.Vector) (uint64, error)
+	// InsertCompares adds the news to the group, duplicateOf is the original of a near-duplicate
+	// and 0 otherwise. Duplicates do not move the centroid.
+	InsertCompares(ctx context.Context, groupID uint64, feedID uint64, vec *vector.Vector, duplicateOf int64) error
+	InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error
+	UpdateParsed(ctx context.Context, id uint64, parsed bool) error
+	UpdateCentroid(ctx context.Context, groupID uint64) (*vector.Vector, error)
+	// JoinCentroid updates the centroid after the news with vec joined the group
+	JoinCentroid(ctx context.Context, groupID uint64, vec *vector.Vector) (*vector.Vector, error)
+	GetCluster(ctx context.Context, id uint64) (Cluster, error)
+	GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error)
+	GetClusterDocument(ctx context.Context, id uint64) (ClusterDocument, error)
+	GetMembers(ctx context.Context, id uint64, limit int) ([]Member, error)
+	MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error
+	SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error
+	MoveNews(ctx context.Context, feedID, target uint64, actor string) (Edit, error)
+	DetachNews(ctx context.Context, feedID uint64, actor string) (Edit, error)
+	MergeClusters(ctx context.Context, source, target uint64, actor string) (Edit, error)
+	SetPinned(ctx context.Context, feedID uint64, pinned bool, actor string) (Edit, error)
+	SetSummary(ctx context.Context, id uint64, summary string) (int64, error)
+	AddOutbox(ctx context.Context, entry OutboxEntry) error
+	Commit() error
+	Rollback() error
+}
+
+// Store is everything the group maker keeps. DB is the Postgres implementation,
+// memory.Store keeps data in process.
+type Store interface {
+	Groups
+	Compares
+	Feeds
+	RTWords
+	Outbox
+	Begin(ctx context.Context) (Tx, error)
+	Ping(ctx context.Context) error
+	CheckSchema(ctx context.Context) error
+}
+
+var _ Store = (*DB)(nil)
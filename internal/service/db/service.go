@@ -1,23 +1,26 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 
+	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/metrics"
 	"agregator/group/service/vector"
 )
 
 type DB struct {
-	conn *sqlx.DB
+	conn   *sqlx.DB
+	logger interfaces.Logger
 }
 
-func New(maxConnections int) (*DB, error) {
+func New(maxConnections int, logger interfaces.Logger) (*DB, error) {
 	connectionData := fmt.Sprintf(
 		"user=%s dbname=%s sslmode=disable password=%s host=%s port=%s",
 		os.Getenv("DB_LOGIN"),
@@ -35,50 +38,144 @@ func New(maxConnections int) (*DB, error) {
 	conn.SetMaxOpenConns(maxConnections)
 	conn.SetMaxIdleConns(maxConnections / 2)
 	conn.SetConnMaxLifetime(5 * time.Minute)
+	metrics.RegisterDB("newagregator", conn.DB)
 
-	return &DB{conn: conn}, nil
+	return &DB{conn: conn, logger: logger}, nil
 }
 
-func (g *DB) UpdateParsed(id uint64, parsed bool) error {
-	query := `
-        UPDATE feed 
-        SET parsed = $1 
-        WHERE id = $2
-    `
-	_, err := g.conn.Exec(query, parsed, id)
-	return err
+func (g *DB) Close() error {
+	return g.conn.Close()
+}
+
+func (g *DB) Ping(ctx context.Context) error {
+	return g.conn.PingContext(ctx)
+}
+
+func (g *DB) UpdateParsed(ctx context.Context, id uint64, parsed bool) error {
+	return updateParsed(ctx, g.conn, id, parsed)
+}
+
+// Membership describes the cluster of a news
+type Membership struct {
+	GroupID uint64 `db:"group_id"`
+	Founder bool   `db:"founder"`
+	Pinned  bool   `db:"pinned"`
 }
 
-func (g *DB) Insert(t time.Time, feed_id int64, is_rt bool, vec *vector.Vector) (uint64, error) {
-	log.Default().Println("Inserting into DB", "time", t, "feed_id", feed_id, "is_rt", is_rt)
-	var id uint64
-	query := `INSERT INTO groups (time, feed_id, is_rt, embedding)
-			VALUES ($1, $2, $3, $4)
-			ON CONFLICT(feed_id) DO NOTHING
-			RETURNING id`
+// GetGroupByFeed returns group of the news, whether the news founded this group
+// and whether it is pinned by an editor. GroupID is 0 if the news was not clustered yet.
+func (g *DB) GetGroupByFeed(ctx context.Context, feedID uint64) (Membership, error) {
+	return getMembership(ctx, g.conn, feedID, false)
+}
 
-	err := g.conn.QueryRow(query, t, feed_id, is_rt, vec.ToPqString()).Scan(&id)
-	// Проверяем, является ли ошибка sql.ErrNoRows
+func getMembership(ctx context.Context, q sqlx.QueryerContext, feedID uint64, lock bool) (Membership, error) {
+	var result Membership
+	query := `
+        SELECT c.group_id, g.feed_id = c.feed_id AS founder, c.pinned
+        FROM compares c
+        JOIN groups g ON g.id = c.group_id
+        WHERE c.feed_id = $1
+    `
+	if lock {
+		query += " FOR UPDATE OF c"
+	}
+	err := sqlx.GetContext(ctx, q, &result, query, feedID)
 	if err == sql.ErrNoRows {
-		return 0, nil
+		return Membership{}, nil
 	}
+	return result, err
+}
+
+// MoveCompare moves the news with its new embedding to the group, the assignment
+// follows the membership
+func (g *DB) MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error {
+	return moveCompare(ctx, g.conn, feedID, groupID, vec)
+}
 
-	// Если это какая-то другая ошибка, или если все прошло успешно, возвращаем ее
+func moveCompare(ctx context.Context, q sqlx.ExecerContext, feedID uint64, groupID uint64, vec *vector.Vector) error {
+	query := `
+        UPDATE compares 
+        SET group_id = $1, embedding = $2 
+        WHERE feed_id = $3
+    `
+	_, err := q.ExecContext(ctx, query, groupID, vec.ToPqString(), feedID)
 	if err != nil {
-		return 0, err
+		return err
 	}
-
-	// Если ошибок нет, значит, строка была успешно вставлена, и 'id' содержит новый ID.
-	return id, nil
+	_, err = q.ExecContext(ctx, `UPDATE assignments SET group_id = $1 WHERE feed_id = $2`, groupID, feedID)
+	return err
 }
 
-func (g *DB) InsertCompares(groupID uint64, compareID uint64) error {
+// updateCentroid recalculates group embedding as the mean of its members embeddings
+// and resets the running sum. The group row is locked first, so a concurrent join
+// waits for the result instead of overwriting it.
+func updateCentroid(ctx context.Context, q sqlx.ExtContext, groupID uint64) (*vector.Vector, error) {
+	_, err := q.ExecContext(ctx, `SELECT id FROM groups WHERE id = $1 FOR UPDATE`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	var embeddings []string
 	query := `
-        INSERT INTO compares (group_id, feed_id) 
-        VALUES ($1, $2)
+        SELECT embedding::text 
+        FROM compares 
+        WHERE group_id = $1 AND embedding IS NOT NULL AND duplicate_of IS NULL
     `
-	_, err := g.conn.Exec(query, groupID, compareID)
-	return err
+	err = sqlx.SelectContext(ctx, q, &embeddings, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		_, err = q.ExecContext(ctx, `UPDATE groups SET embedding_sum = NULL, embedding_count = 0 WHERE id = $1`, groupID)
+		return nil, err
+	}
+
+	var sum *vector.Vector
+	for _, embedding := range embeddings {
+		vec, err := vector.FromPqString(embedding)
+		if err != nil {
+			return nil, err
+		}
+		if sum == nil {
+			sum = vec
+			continue
+		}
+		sum.Add(vec)
+	}
+	return storeCentroid(ctx, q, groupID, sum, len(embeddings))
+}
+
+// joinCentroid adds the embedding of a new member to the running sum of the group
+// and stores the mean without reading other members. The group row stays locked
+// until the end of the transaction, so concurrent joins are applied one after another.
+func joinCentroid(ctx context.Context, q sqlx.ExtContext, groupID uint64, vec *vector.Vector) (*vector.Vector, error) {
+	var row struct {
+		Sum   sql.NullString `db:"embedding_sum"`
+		Count int            `db:"embedding_count"`
+	}
+	query := `SELECT embedding_sum::text AS embedding_sum, embedding_count FROM groups WHERE id = $1 FOR UPDATE`
+	err := sqlx.GetContext(ctx, q, &row, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if !row.Sum.Valid {
+		// Сумма еще не посчитана, новый участник уже записан и войдет в пересчет
+		return updateCentroid(ctx, q, groupID)
+	}
+	sum, err := vector.FromPqString(row.Sum.String)
+	if err != nil {
+		return nil, err
+	}
+	return storeCentroid(ctx, q, groupID, sum.Add(vec), row.Count+1)
+}
+
+func storeCentroid(ctx context.Context, q sqlx.ExecerContext, groupID uint64, sum *vector.Vector, count int) (*vector.Vector, error) {
+	centroid := vector.New(sum.GetArray()).Divide(float64(count))
+	query := `UPDATE groups SET embedding = $1, embedding_sum = $2, embedding_count = $3 WHERE id = $4`
+	_, err := q.ExecContext(ctx, query, centroid.ToPqString(), sum.ToPqString(), count, groupID)
+	if err != nil {
+		return nil, err
+	}
+	return centroid, nil
 }
 
 func (g *DB) GetRTWords() ([]string, error) {
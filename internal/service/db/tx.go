@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/tracing"
+	"agregator/group/service/vector"
+)
+
+// postgresTx groups repository writes of one news assignment into a single transaction
+type postgresTx struct {
+	tx *sqlx.Tx
+}
+
+func (g *DB) Begin(ctx context.Context) (Tx, error) {
+	tx, err := g.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresTx{tx: tx}, nil
+}
+
+func (g *DB) inTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := g.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *postgresTx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback does nothing if the transaction is already committed
+func (t *postgresTx) Rollback() error {
+	err := t.tx.Rollback()
+	if err == sql.ErrTxDone {
+		return nil
+	}
+	return err
+}
+
+// InsertGroup returns 0 if a group founded by feed_id already exists
+func (t *postgresTx) InsertGroup(ctx context.Context, date time.Time, feedID int64, isRT bool, vec *vector.Vector) (uint64, error) {
+	return insertGroup(ctx, t.tx, date, feedID, isRT, vec)
+}
+
+func (t *postgresTx) InsertCompares(ctx context.Context, groupID uint64, feedID uint64, vec *vector.Vector, duplicateOf int64) error {
+	return insertCompares(ctx, t.tx, groupID, feedID, vec, duplicateOf)
+}
+
+func (t *postgresTx) InsertAssignment(ctx context.Context, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error {
+	return insertAssignment(ctx, t.tx, feedID, groupID, candidates, decision)
+}
+
+func (t *postgresTx) UpdateParsed(ctx context.Context, id uint64, parsed bool) error {
+	return updateParsed(ctx, t.tx, id, parsed)
+}
+
+func (t *postgresTx) UpdateCentroid(ctx context.Context, groupID uint64) (*vector.Vector, error) {
+	return updateCentroid(ctx, t.tx, groupID)
+}
+
+func (t *postgresTx) JoinCentroid(ctx context.Context, groupID uint64, vec *vector.Vector) (*vector.Vector, error) {
+	return joinCentroid(ctx, t.tx, groupID, vec)
+}
+
+func (t *postgresTx) GetCluster(ctx context.Context, id uint64) (Cluster, error) {
+	return getCluster(ctx, t.tx, id)
+}
+
+func (t *postgresTx) GetMembers(ctx context.Context, id uint64, limit int) ([]Member, error) {
+	return getMembers(ctx, t.tx, id, limit)
+}
+
+func (t *postgresTx) GetCentroid(ctx context.Context, id uint64) (*vector.Vector, error) {
+	return getCentroid(ctx, t.tx, id)
+}
+
+func (t *postgresTx) GetClusterDocument(ctx context.Context, id uint64) (ClusterDocument, error) {
+	return getClusterDocument(ctx, t.tx, id)
+}
+
+func (t *postgresTx) MoveCompare(ctx context.Context, feedID uint64, groupID uint64, vec *vector.Vector) error {
+	return moveCompare(ctx, t.tx, feedID, groupID, vec)
+}
+
+func (t *postgresTx) SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error {
+	return setRepresentative(ctx, t.tx, id, feedID, headline)
+}
+
+func insertGroup(ctx context.Context, q sqlx.QueryerContext, date time.Time, feedID int64, isRT bool, vec *vector.Vector) (_ uint64, err error) {
+	ctx, span := tracing.Start(ctx, "db.insert_group")
+	defer func() { tracing.End(span, err) }()
+
+	var id uint64
+	query := `INSERT INTO groups (time, feed_id, is_rt, embedding, embedding_sum, embedding_count)
+			VALUES ($1, $2, $3, $4, $4, 1)
+			ON CONFLICT(feed_id) DO NOTHING
+			RETURNING id`
+
+	err = q.QueryRowxContext(ctx, query, date, feedID, isRT, vec.ToPqString()).Scan(&id)
+	// Проверяем, является ли ошибка sql.ErrNoRows
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func insertCompares(ctx context.Context, q sqlx.ExecerContext, groupID uint64, feedID uint64, vec *vector.Vector, duplicateOf int64) (err error) {
+	ctx, span := tracing.Start(ctx, "db.insert_compares")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+        INSERT INTO compares (group_id, feed_id, embedding, duplicate_of) 
+        VALUES ($1, $2, $3, NULLIF($4, 0))
+    `
+	_, err = q.ExecContext(ctx, query, groupID, feedID, vec.ToPqString(), duplicateOf)
+	return err
+}
+
+func insertAssignment(ctx context.Context, q sqlx.ExecerContext, feedID uint64, groupID uint64, candidates []model.Candidate, decision *model.Decision) error {
+	if candidates == nil {
+		candidates = []model.Candidate{}
+	}
+	data, err := json.Marshal(candidates)
+	if err != nil {
+		return err
+	}
+	decisionData, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	query := `
+        INSERT INTO assignments (feed_id, group_id, candidates, decision) 
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (feed_id) DO UPDATE 
+        SET group_id = EXCLUDED.group_id, candidates = EXCLUDED.candidates, decision = EXCLUDED.decision, created_at = now()
+    `
+	_, err = q.ExecContext(ctx, query, feedID, groupID, data, decisionData)
+	return err
+}
+
+func updateParsed(ctx context.Context, q sqlx.ExecerContext, id uint64, parsed bool) (err error) {
+	ctx, span := tracing.Start(ctx, "db.update_parsed")
+	defer func() { tracing.End(span, err) }()
+
+	query := `
+        UPDATE feed 
+        SET parsed = $1 
+        WHERE id = $2
+    `
+	_, err = q.ExecContext(ctx, query, parsed, id)
+	return err
+}
@@ -0,0 +1,168 @@
+package duplicate
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+)
+
+const (
+	shingleSize = 3
+	bands       = 4
+	bandBits    = 64 / bands
+	minTokens   = 20
+)
+
+// Original is an already clustered article which duplicates are linked to. Its cluster
+// is not kept, it changes when editors move or merge clusters.
+type Original struct {
+	Hash      uint64
+	NewsID    int64
+	IsRT      bool
+	Embedding []float64
+}
+
+// Index keeps SimHash fingerprints of recent articles.
+// Fingerprints are split into bands, so any fingerprint within maxDistance < bands
+// bits shares at least one band with the searched one.
+// The index lives in process memory and starts empty: after a restart duplicates of
+// earlier articles are not detected and are clustered by their embeddings.
+type Index struct {
+	mu          sync.RWMutex
+	bands       [bands]map[uint64][]*Original
+	order       []*Original
+	capacity    int
+	maxDistance int
+	logger      interfaces.Logger
+}
+
+func New(logger interfaces.Logger) *Index {
+	capacity, err := strconv.Atoi(os.Getenv("DUPLICATE_CAPACITY"))
+	if err != nil || capacity <= 0 {
+		logger.Info("Invalid DUPLICATE_CAPACITY value, defaulting to 100000")
+		capacity = 100000
+	}
+	maxDistance, err := strconv.Atoi(os.Getenv("DUPLICATE_DISTANCE"))
+	if err != nil || maxDistance < 0 || maxDistance >= bands {
+		logger.Info("Invalid DUPLICATE_DISTANCE value, defaulting to 3")
+		maxDistance = 3
+	}
+	index := &Index{
+		capacity:    capacity,
+		maxDistance: maxDistance,
+		order:       make([]*Original, 0, capacity),
+		logger:      logger,
+	}
+	for i := range index.bands {
+		index.bands[i] = make(map[uint64][]*Original)
+	}
+	return index
+}
+
+// Hash returns SimHash of normalized article text.
+// ok is false when the text is too short to be fingerprinted reliably.
+func Hash(item *model.News) (uint64, bool) {
+	text := item.FullText
+	if len([]rune(text)) < len([]rune(item.Description)) {
+		text = item.Description
+	}
+	tokens := strings.FieldsFunc(strings.ToLower(item.Title+" "+text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(tokens) < minTokens {
+		return 0, false
+	}
+
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var result uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			result |= 1 << bit
+		}
+	}
+	return result, true
+}
+
+func band(hash uint64, i int) uint64 {
+	return (hash >> (i * bandBits)) & (1<<bandBits - 1)
+}
+
+// Find returns the closest indexed article within the configured Hamming distance
+func (idx *Index) Find(hash uint64) (*Original, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var best *Original
+	bestDistance := idx.maxDistance + 1
+	for i := range idx.bands {
+		for _, candidate := range idx.bands[i][band(hash, i)] {
+			distance := bits.OnesCount64(candidate.Hash ^ hash)
+			if distance < bestDistance {
+				best = candidate
+				bestDistance = distance
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// Add registers a clustered article, evicting the oldest one if the index is full
+func (idx *Index) Add(hash uint64, item *model.News) {
+	original := &Original{
+		Hash:      hash,
+		NewsID:    item.ID,
+		IsRT:      item.IsRT,
+		Embedding: item.Embedding,
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if len(idx.order) >= idx.capacity {
+		idx.remove(idx.order[0])
+		idx.order = idx.order[1:]
+	}
+	idx.order = append(idx.order, original)
+	for i := range idx.bands {
+		key := band(hash, i)
+		idx.bands[i][key] = append(idx.bands[i][key], original)
+	}
+}
+
+func (idx *Index) remove(original *Original) {
+	for i := range idx.bands {
+		key := band(original.Hash, i)
+		list := idx.bands[i][key]
+		for j, candidate := range list {
+			if candidate == original {
+				list = append(list[:j], list[j+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(idx.bands[i], key)
+		} else {
+			idx.bands[i][key] = list
+		}
+	}
+}
@@ -1,27 +1,99 @@
+// Package elastic is the client of the search sidecar at ELASTIC_HOST. The sidecar
+// keeps one document per cluster and answers POST requests with JSON bodies:
+//
+//	/get       {"embedding": [...], "limit": n} → {"items": [Cluster...]}, closest clusters first; title, description
+//	           and text are optional, the groupmaker loads missing ones from its store
+//	/register  Document → 200, adds the cluster or replaces its document
+//	/delete    {"id": n} → 200, removes the cluster, unknown ids are not an error
+//
+// GET / is the health check. Any other status is an error, index entries of the outbox
+// are retried until OUTBOX_MAX_ATTEMPTS.
 package elastic
 
 import (
+	"agregator/group/internal/interfaces"
 	"agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/tracing"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type Elastic struct {
-	url string
+	url    string
+	client *http.Client
+	logger interfaces.Logger
 }
 
-func New() *Elastic {
+func New(logger interfaces.Logger) *Elastic {
 	return &Elastic{
-		url: os.Getenv("ELASTIC_HOST"),
+		url:    os.Getenv("ELASTIC_HOST"),
+		logger: logger,
+		client: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   10 * time.Second, // Таймаут для HTTP-запросов
+		},
+	}
+}
+
+func (e *Elastic) post(ctx context.Context, path string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return e.client.Do(req)
+}
+
+// DeleteCluster removes cluster from the index
+func (e *Elastic) DeleteCluster(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.Start(ctx, "elastic.delete")
+	defer func() { tracing.End(span, err) }()
+
+	data, err := json.Marshal(map[string]int64{"id": id})
+	if err != nil {
+		return err
+	}
+	resp, err := e.post(ctx, "/delete", data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Ping checks that search sidecar responds
+func (e *Elastic) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
 }
 
-func (e *Elastic) GetClosest(embedding []float64, limit int) ([]kafka.Cluster, error) {
+func (e *Elastic) GetClosest(ctx context.Context, embedding []float64, limit int) (items []kafka.Cluster, err error) {
+	ctx, span := tracing.Start(ctx, "elastic.get_closest")
+	defer func() { tracing.End(span, err) }()
+
 	type Request struct {
 		Embedding []float64 `json:"embedding"`
 		Limit     int       `json:"limit"`
@@ -34,10 +106,13 @@ func (e *Elastic) GetClosest(embedding []float64, limit int) ([]kafka.Cluster, e
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.Post(e.url+"/get", "application/json", bytes.NewReader(data))
+	start := time.Now()
+	resp, err := e.post(ctx, "/get", data)
+	metrics.SearchDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -50,40 +125,50 @@ func (e *Elastic) GetClosest(embedding []float64, limit int) ([]kafka.Cluster, e
 	if err != nil {
 		return nil, err
 	}
-	log.Default().Println("Получены кластеры по совпадению:", string(byteData))
+	e.logger.Debug("Got closest clusters", "stage", "search", "response", byteData, "duration", time.Since(start))
 	err = json.Unmarshal(byteData, &respStruct)
 	if err != nil {
 		return nil, err
 	}
+	metrics.SearchCandidates.Observe(float64(len(respStruct.Items)))
 	return respStruct.Items, nil
 }
 
-func (e *Elastic) RegisterClusert(id int64, publishDate string, embedding []float64, title, fullText, description string) error {
-	type Request struct {
-		Id          int64     `json:"id"`
-		PublishDate string    `json:"publishDate"`
-		Embedding   []float64 `json:"embedding"`
-		Title       string    `json:"title"`
-		Rewrite     string    `json:"text"`
-		Description string    `json:"description"`
-	}
-	var req Request = Request{
+// Document is a cluster as registered in the search index
+type Document struct {
+	Id          int64     `json:"id"`
+	PublishDate string    `json:"publishDate"`
+	Embedding   []float64 `json:"embedding"`
+	Title       string    `json:"title"`
+	Rewrite     string    `json:"text"`
+	Description string    `json:"description"`
+}
+
+func (e *Elastic) RegisterClusert(ctx context.Context, id int64, publishDate string, embedding []float64, title, fullText, description string) error {
+	return e.RegisterDocument(ctx, Document{
 		Id:          id,
 		PublishDate: publishDate,
 		Embedding:   embedding,
 		Title:       title,
 		Rewrite:     fullText,
 		Description: description,
-	}
+	})
+}
 
-	data, err := json.Marshal(req)
+// RegisterDocument adds the cluster to the index or replaces it
+func (e *Elastic) RegisterDocument(ctx context.Context, doc Document) (err error) {
+	ctx, span := tracing.Start(ctx, "elastic.register")
+	defer func() { tracing.End(span, err) }()
+
+	data, err := json.Marshal(doc)
 	if err != nil {
 		return err
 	}
-	resp, err := http.Post(e.url+"/register", "application/json", bytes.NewReader(data))
+	resp, err := e.post(ctx, "/register", data)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
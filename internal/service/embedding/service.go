@@ -2,69 +2,94 @@ package embedding
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	cfg "agregator/group/internal/config/yandex/embedding"
 	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/tracing"
 	"agregator/group/service/vector"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type Service struct {
-	maxRequests int
-	mu          sync.Mutex
-	cond        *sync.Cond
-	client      *http.Client
-	logger      interfaces.Logger
+	// slots ограничивает число одновременных запросов к API (MAX_REQUESTS)
+	slots  chan struct{}
+	url    string
+	client *http.Client
+	logger interfaces.Logger
+
+	// checking guards the cached check result, a probe waiting for it gives up with its ctx
+	checking  chan struct{}
+	checkedAt time.Time
+	checkErr  error
 }
 
+const (
+	checkTTL = 5 * time.Minute
+	// failures are cached shortly, so a transient error does not keep the service unready
+	checkErrorTTL = 10 * time.Second
+)
+
 func New(logger interfaces.Logger) *Service {
 	maxReqStr := os.Getenv("MAX_REQUESTS")
 	maxReq, err := strconv.Atoi(maxReqStr)
-	if err != nil {
+	if err != nil || maxReq <= 0 {
 		logger.Info("Invalid MAX_REQUESTS value, defaulting to 10")
 		maxReq = 10
 	}
 
-	service := &Service{
-		maxRequests: maxReq,
+	url := os.Getenv("YANDEX_EMBEDDING_URL")
+	if url == "" {
+		url = cfg.URL
+	}
+
+	return &Service{
+		slots: make(chan struct{}, maxReq),
+		url:   url,
 		client: &http.Client{
-			Timeout: 60 * time.Second, // Таймаут для HTTP-запросов
+			Timeout:   60 * time.Second, // Таймаут для HTTP-запросов
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
 		},
-		logger: logger,
+		logger:   logger,
+		checking: make(chan struct{}, 1),
 	}
-	service.cond = sync.NewCond(&service.mu)
-	return service
 }
 
-func (s *Service) wait() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	for s.maxRequests <= 0 {
-		s.cond.Wait()
+// wait takes a request slot or returns the ctx error
+func (s *Service) wait(ctx context.Context) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	s.maxRequests--
 }
 
 func (s *Service) release() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.maxRequests++
-	s.cond.Signal()
+	<-s.slots
 }
 
-func (s *Service) sendRequest(text string) (cfg.Response, error) {
-	s.wait()
+func (s *Service) sendRequest(ctx context.Context, text string) (cfg.Response, error) {
+	if err := s.wait(ctx); err != nil {
+		return cfg.Response{}, err
+	}
 	defer s.release()
 
+	start := time.Now()
+	defer func() {
+		metrics.EmbeddingDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	request := cfg.Request{
 		ModelURI: cfg.MODEL_URI,
 		Text:     text,
@@ -75,7 +100,7 @@ func (s *Service) sendRequest(text string) (cfg.Response, error) {
 		return cfg.Response{}, err
 	}
 
-	req, err := http.NewRequest("POST", cfg.URL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(data))
 	if err != nil {
 		s.logger.Error("Error creating request", "error", err)
 		return cfg.Response{}, err
@@ -86,15 +111,17 @@ func (s *Service) sendRequest(text string) (cfg.Response, error) {
 
 	response, err := s.client.Do(req)
 	if err != nil {
+		metrics.EmbeddingErrors.Inc()
 		s.logger.Error("Error sending request", "error", err)
 		return cfg.Response{}, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
+		metrics.EmbeddingErrors.Inc()
 		body, _ := io.ReadAll(response.Body)
-		s.logger.Info("Error response from API", "status", response.StatusCode, "body", string(body))
-		return cfg.Response{}, err
+		s.logger.Error("Error response from API", "status", response.StatusCode, "body", string(body))
+		return cfg.Response{}, fmt.Errorf("unexpected status code: %d", response.StatusCode)
 	}
 
 	var apiResponse cfg.Response
@@ -108,13 +135,17 @@ func (s *Service) sendRequest(text string) (cfg.Response, error) {
 		s.logger.Error("Error unmarshaling response", "error", err)
 		return cfg.Response{}, err
 	}
-	if os.Getenv("DEBUG") == "true" {
-		s.logger.Info("Response from API", "response", string(ans_data))
+	if tokens, err := strconv.Atoi(apiResponse.NumTokens); err == nil {
+		metrics.EmbeddingTokens.Add(float64(tokens))
 	}
+	s.logger.Debug("Response from API", "stage", "embed", "model_version", apiResponse.ModelVersion, "tokens", apiResponse.NumTokens, "duration", time.Since(start))
 	return apiResponse, nil
 }
 
-func (s *Service) GetEmbedding(title, description, full_text string) (*vector.Vector, error) {
+func (s *Service) GetEmbedding(ctx context.Context, title, description, full_text string) (_ *vector.Vector, err error) {
+	ctx, span := tracing.Start(ctx, "embedding.get")
+	defer func() { tracing.End(span, err) }()
+
 	if full_text == description {
 		full_text = ""
 	}
@@ -125,7 +156,7 @@ func (s *Service) GetEmbedding(title, description, full_text string) (*vector.Ve
 	if len(text) > 4000 {
 		text = title
 	}
-	response, err := s.sendRequest(text)
+	response, err := s.sendRequest(ctx, text)
 	if err != nil {
 		s.logger.Error("Error getting embedding", "error", err)
 		return vector.NewZeroVector(1), err
@@ -133,6 +164,28 @@ func (s *Service) GetEmbedding(title, description, full_text string) (*vector.Ve
 	return vector.New(response.Embedding), nil
 }
 
+// Ping checks that embedding provider works. Success is cached for checkTTL
+// so readiness probes don't spend tokens on every call, failure for checkErrorTTL.
+func (s *Service) Ping(ctx context.Context) error {
+	select {
+	case s.checking <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-s.checking }()
+	ttl := checkTTL
+	if s.checkErr != nil {
+		ttl = checkErrorTTL
+	}
+	if time.Since(s.checkedAt) < ttl {
+		return s.checkErr
+	}
+	_, err := s.sendRequest(ctx, "ping")
+	s.checkedAt = time.Now()
+	s.checkErr = err
+	return err
+}
+
 func (s *Service) GetSimilarity(text1, text2 *vector.Vector) float64 {
 	return text1.CosDistance(text2)
 }
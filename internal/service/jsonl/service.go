@@ -0,0 +1,161 @@
+package jsonl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/tracing"
+)
+
+const maxLine = 16 << 20
+
+// Source reads model.Item JSON lines from a file or stdin and ends with the input
+type Source struct {
+	reader      io.ReadCloser
+	textChannel chan model.News
+	logger      interfaces.Logger
+}
+
+var _ interfaces.Source = (*Source)(nil)
+
+// NewSource opens path, empty path or "-" means stdin
+func NewSource(path string, logger interfaces.Logger) (*Source, error) {
+	reader := io.ReadCloser(os.Stdin)
+	if path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		reader = file
+	}
+	return &Source{
+		reader:      reader,
+		textChannel: make(chan model.News, 100),
+		logger:      logger,
+	}, nil
+}
+
+func (s *Source) TextOutput() <-chan model.News {
+	return s.textChannel
+}
+
+func (s *Source) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Source) StartReadingText(ctx context.Context) {
+	defer func() {
+		s.reader.Close()
+		close(s.textChannel)
+	}()
+
+	scanner := bufio.NewScanner(s.reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+		metrics.TransportConsumed.WithLabelValues("jsonl").Inc()
+		item, err := schema.DecodeItem(data, nil, time.Now())
+		if err != nil {
+			// Для файлов DLQ нет: отклоненные строки только логируются
+			metrics.MessagesRejected.WithLabelValues("jsonl").Inc()
+			s.logger.Warn("Rejecting line", "error", err, "line", line)
+			continue
+		}
+		select {
+		case s.textChannel <- item.News():
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.logger.Error("Error reading input", "error", err, "line", line)
+	}
+}
+
+// Line is a message written by Sink. Value holds JSON payloads as is,
+// other payloads are base64 encoded in Data.
+type Line struct {
+	Event   string            `json:"event"`
+	Key     string            `json:"key,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Value   json.RawMessage   `json:"value,omitempty"`
+	Data    []byte            `json:"data,omitempty"`
+}
+
+// Sink writes messages as JSON lines to a file or stdout.
+// Without envelope only message values are written.
+type Sink struct {
+	mu       sync.Mutex
+	writer   io.Writer
+	envelope bool
+	logger   interfaces.Logger
+}
+
+var _ interfaces.Sink = (*Sink)(nil)
+
+// NewSink opens path for appending, empty path or "-" means stdout
+func NewSink(path string, envelope bool, logger interfaces.Logger) (*Sink, error) {
+	writer := io.Writer(os.Stdout)
+	if path != "" && path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		writer = file
+	}
+	return &Sink{writer: writer, envelope: envelope, logger: logger}, nil
+}
+
+func (s *Sink) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Sink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) error {
+	if !s.envelope {
+		return s.write(event, message)
+	}
+	line := Line{
+		Event:   event,
+		Key:     string(key),
+		Headers: make(map[string]string, len(headers)),
+	}
+	for key, value := range headers {
+		line.Headers[key] = value
+	}
+	tracing.Inject(ctx, line.Headers)
+	if json.Valid(message) {
+		line.Value = message
+	} else {
+		line.Data = message
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	return s.write(event, data)
+}
+
+func (s *Sink) write(event string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.writer.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	metrics.TransportProduced.WithLabelValues("jsonl", event).Inc()
+	return nil
+}
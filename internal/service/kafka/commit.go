@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// offsets commits fetched messages in order. Workers finish news in any order,
+// an offset is committed only when all earlier messages of its partition are processed.
+type offsets struct {
+	mu      sync.Mutex
+	pending map[int][]*fetched
+}
+
+// fetched is a message handed over to processing
+type fetched struct {
+	msg  kafka.Message
+	done bool
+}
+
+func newOffsets() *offsets {
+	return &offsets{pending: make(map[int][]*fetched)}
+}
+
+// add registers the fetched message. An offset not greater than the last one means
+// the partition is read again after a rebalance, earlier messages are forgotten.
+func (o *offsets) add(msg kafka.Message) *fetched {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	queue := o.pending[msg.Partition]
+	if len(queue) > 0 && queue[len(queue)-1].msg.Offset >= msg.Offset {
+		queue = nil
+	}
+	// Значение не нужно для коммита, не держим его в памяти
+	f := &fetched{msg: kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}}
+	o.pending[msg.Partition] = append(queue, f)
+	return f
+}
+
+// done marks the message processed and commits the last message of the processed
+// prefix of its partition
+func (o *offsets) done(ctx context.Context, f *fetched, commit func(ctx context.Context, msgs ...kafka.Message) error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f.done = true
+	queue := o.pending[f.msg.Partition]
+	n := 0
+	for n < len(queue) && queue[n].done {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	last := queue[n-1].msg
+	o.pending[f.msg.Partition] = queue[n:]
+	// Коммит под блокировкой, чтобы меньшее смещение не ушло после большего
+	return commit(ctx, last)
+}
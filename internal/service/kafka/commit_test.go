@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOffsetsCommitInOrder(t *testing.T) {
+	o := newOffsets()
+	var committed []int64
+	commit := func(ctx context.Context, msgs ...kafka.Message) error {
+		for _, msg := range msgs {
+			committed = append(committed, int64(msg.Partition)*100+msg.Offset)
+		}
+		return nil
+	}
+	fetch := func(partition int, offset int64) *fetched {
+		return o.add(kafka.Message{Topic: "news", Partition: partition, Offset: offset, Value: []byte("value")})
+	}
+	done := func(f *fetched) {
+		if err := o.done(context.Background(), f, commit); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, second, third := fetch(0, 10), fetch(0, 11), fetch(0, 12)
+	other := fetch(1, 5)
+	done(third)
+	done(second)
+	if len(committed) != 0 {
+		t.Fatalf("committed %v before the first message was processed", committed)
+	}
+	done(other)
+	done(first)
+	if want := []int64{105, 12}; !reflect.DeepEqual(committed, want) {
+		t.Fatalf("committed %v, want %v", committed, want)
+	}
+	if first.msg.Value != nil {
+		t.Error("pending message keeps its value")
+	}
+
+	// После ребалансировки партиция читается заново с закоммиченного смещения
+	committed = nil
+	stale := fetch(0, 13)
+	again := fetch(0, 13)
+	done(stale)
+	if len(committed) != 0 {
+		t.Fatalf("stale message committed %v", committed)
+	}
+	done(again)
+	if want := []int64{13}; !reflect.DeepEqual(committed, want) {
+		t.Fatalf("committed %v, want %v", committed, want)
+	}
+}
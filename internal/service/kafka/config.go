@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Config describes connection, consumer and producer settings
+type Config struct {
+	Brokers     []string
+	GroupID     string
+	InputTopic  string
+	OutputTopic string
+	ClientID    string
+	// DLQTopic receives input messages failing validation
+	DLQTopic string
+	// ClusterTopic receives cluster lifecycle events
+	ClusterTopic string
+	// StartOffset is "earliest" or "latest", used when the group has no committed offset
+	StartOffset string
+
+	// SASLMechanism is "plain", "scram-sha-256" or "scram-sha-512", empty disables SASL
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+
+	TLS           bool
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSSkipVerify bool
+
+	// Acks is "all", "one" or "none"
+	Acks string
+	// Compression is "none", "gzip", "snappy", "lz4" or "zstd"
+	Compression  string
+	BatchSize    int
+	BatchTimeout time.Duration
+	// Idempotent forces acks=all and key hash partitioning, so retried messages
+	// keep their order within a key. kafka-go has no idempotent producer, duplicates
+	// are dropped by consumers using the idempotency-key header.
+	Idempotent bool
+}
+
+func getenv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// ConfigFromEnv reads KAFKA_* variables. KAFKA_BROKERS is a comma separated list,
+// KAFKA_HOST is used when it is not set.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		GroupID:       getenv("KAFKA_GROUP_ID", "aggregator-group"),
+		InputTopic:    getenv("KAFKA_INPUT_TOPIC", "group-maker"),
+		OutputTopic:   getenv("KAFKA_OUTPUT_TOPIC", "elastic-text-read"),
+		DLQTopic:      getenv("KAFKA_DLQ_TOPIC", "group-maker-dlq"),
+		ClusterTopic:  getenv("KAFKA_CLUSTER_TOPIC", "cluster-events"),
+		ClientID:      getenv("KAFKA_CLIENT_ID", "group-maker"),
+		StartOffset:   getenv("KAFKA_START_OFFSET", "earliest"),
+		SASLMechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+		SASLUsername:  os.Getenv("KAFKA_SASL_USERNAME"),
+		SASLPassword:  os.Getenv("KAFKA_SASL_PASSWORD"),
+		TLS:           os.Getenv("KAFKA_TLS") == "true",
+		TLSCAFile:     os.Getenv("KAFKA_TLS_CA"),
+		TLSCertFile:   os.Getenv("KAFKA_TLS_CERT"),
+		TLSKeyFile:    os.Getenv("KAFKA_TLS_KEY"),
+		TLSSkipVerify: os.Getenv("KAFKA_TLS_SKIP_VERIFY") == "true",
+		Acks:          getenv("KAFKA_ACKS", "one"),
+		Compression:   getenv("KAFKA_COMPRESSION", "none"),
+		// Outbox relay пишет пачками, поэтому ждать заполнения пачки долго не нужно
+		BatchSize:    100,
+		BatchTimeout: 10 * time.Millisecond,
+		Idempotent:   os.Getenv("KAFKA_IDEMPOTENT") == "true",
+	}
+	for _, broker := range strings.Split(getenv("KAFKA_BROKERS", os.Getenv("KAFKA_HOST")), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			config.Brokers = append(config.Brokers, broker)
+		}
+	}
+	if value := os.Getenv("KAFKA_BATCH_SIZE"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return Config{}, fmt.Errorf("invalid KAFKA_BATCH_SIZE %q", value)
+		}
+		config.BatchSize = size
+	}
+	if value := os.Getenv("KAFKA_BATCH_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return Config{}, fmt.Errorf("invalid KAFKA_BATCH_TIMEOUT %q", value)
+		}
+		config.BatchTimeout = timeout
+	}
+	return config, nil
+}
+
+func (c Config) startOffset() (int64, error) {
+	switch c.StartOffset {
+	case "", "earliest":
+		return kafka.FirstOffset, nil
+	case "latest":
+		return kafka.LastOffset, nil
+	}
+	return 0, fmt.Errorf("unknown start offset %q", c.StartOffset)
+}
+
+func (c Config) acks() (kafka.RequiredAcks, error) {
+	if c.Idempotent {
+		return kafka.RequireAll, nil
+	}
+	switch c.Acks {
+	case "all", "-1":
+		return kafka.RequireAll, nil
+	case "", "one", "1":
+		return kafka.RequireOne, nil
+	case "none", "0":
+		return kafka.RequireNone, nil
+	}
+	return 0, fmt.Errorf("unknown acks %q", c.Acks)
+}
+
+func (c Config) compression() (kafka.Compression, error) {
+	switch c.Compression {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", c.Compression)
+}
+
+func (c Config) balancer() kafka.Balancer {
+	if c.Idempotent {
+		return &kafka.Hash{}
+	}
+	return &kafka.LeastBytes{}
+}
+
+func (c Config) sasl() (sasl.Mechanism, error) {
+	switch strings.ToLower(c.SASLMechanism) {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: c.SASLUsername, Password: c.SASLPassword}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.SASLUsername, c.SASLPassword)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.SASLUsername, c.SASLPassword)
+	}
+	return nil, fmt.Errorf("unknown SASL mechanism %q", c.SASLMechanism)
+}
+
+func (c Config) tls() (*tls.Config, error) {
+	if !c.TLS {
+		return nil, nil
+	}
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.TLSSkipVerify,
+	}
+	if c.TLSCAFile != "" {
+		ca, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates in %s", c.TLSCAFile)
+		}
+	}
+	if c.TLSCertFile != "" || c.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
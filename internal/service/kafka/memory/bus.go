@@ -0,0 +1,152 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/tracing"
+)
+
+// Message is a message written to the output topic
+type Message struct {
+	Event   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// News decodes the message value
+func (m Message) News() (model.News, error) {
+	var news model.News
+	err := json.Unmarshal(m.Value, &news)
+	return news, err
+}
+
+// Bus is an in-process stand-in for Kafka. Published items are delivered to the
+// reader in order, written messages are kept in memory.
+type Bus struct {
+	input     chan model.News
+	closeOnce sync.Once
+	mu        sync.Mutex
+	messages  []Message
+	writeErr  error
+	acked     []int64
+}
+
+var (
+	_ interfaces.Source    = (*Bus)(nil)
+	_ interfaces.BatchSink = (*Bus)(nil)
+)
+
+func New(buffer int) *Bus {
+	return &Bus{input: make(chan model.News, buffer)}
+}
+
+// Publish puts the item into the input topic
+func (b *Bus) Publish(item model.Item) {
+	b.PublishNews(item.News(), nil)
+}
+
+// PublishNews puts already converted news with message headers into the input topic.
+// Acknowledgements are recorded, news.Ack if set is called too.
+func (b *Bus) PublishNews(news model.News, headers map[string]string) {
+	if news.Trace == nil {
+		news.Trace = make(map[string]string, len(headers))
+	}
+	for key, value := range headers {
+		news.Trace[key] = value
+	}
+	id, ack := news.ID, news.Ack
+	news.Ack = func() {
+		if ack != nil {
+			ack()
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.acked = append(b.acked, id)
+	}
+	b.input <- news
+}
+
+// Close ends the input topic, the reader stops after reading published items
+func (b *Bus) Close() {
+	b.closeOnce.Do(func() { close(b.input) })
+}
+
+// FailWrites makes all following writes return err, nil restores them
+func (b *Bus) FailWrites(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writeErr = err
+}
+
+// Messages returns copy of written messages
+func (b *Bus) Messages() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.messages...)
+}
+
+// Acked returns ids of acknowledged news in the order of acknowledgement
+func (b *Bus) Acked() []int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int64(nil), b.acked...)
+}
+
+func (b *Bus) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *Bus) TextOutput() <-chan model.News {
+	return b.input
+}
+
+func (b *Bus) StartReadingText(ctx context.Context) {
+	<-ctx.Done()
+	b.Close()
+}
+
+func (b *Bus) WriteMessage(ctx context.Context, event string, key, message []byte, extra map[string]string) error {
+	return b.write(ctx, event, key, message, extra)
+}
+
+// WriteMessages writes all messages or none of them
+func (b *Bus) WriteMessages(ctx context.Context, messages []interfaces.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.writeErr != nil {
+		return b.writeErr
+	}
+	for _, message := range messages {
+		b.append(ctx, message.Event, message.Key, message.Value, message.Headers)
+	}
+	return nil
+}
+
+func (b *Bus) write(ctx context.Context, event string, key, message []byte, extra map[string]string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.writeErr != nil {
+		return b.writeErr
+	}
+	b.append(ctx, event, key, message, extra)
+	return nil
+}
+
+func (b *Bus) append(ctx context.Context, event string, key, message []byte, extra map[string]string) {
+	headers := map[string]string{"event": event}
+	for key, value := range extra {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+	b.messages = append(b.messages, Message{
+		Event:   event,
+		Key:     append([]byte(nil), key...),
+		Value:   append([]byte(nil), message...),
+		Headers: headers,
+	})
+}
@@ -2,44 +2,144 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"fmt"
+	"strconv"
 	"time"
 
+	"agregator/group/internal/interfaces"
 	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/tracing"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// Kafka reads news from and writes events to Kafka topics.
+// Delivery to the service is at least once: offsets are committed by News.Ack after
+// processing, news in the channel or in processing are read again after a crash or a rebalance.
 type Kafka struct {
 	textReader  *kafka.Reader
+	offsets     *offsets
 	textChannel chan model.News
 	brokers     []string
+	dialer      *kafka.Dialer
 	writeTopic  string
 	writer      *kafka.Writer
+	clusters    *kafka.Writer
+	dlq         *kafka.Writer
+	logger      interfaces.Logger
 }
 
-func New(brokers []string, groupID, textTopic string, writeTopic string) *Kafka {
+// DLQErrorHeader holds the reason why the message was rejected
+const DLQErrorHeader = "dlq-error"
+
+func New(config Config, logger interfaces.Logger) (*Kafka, error) {
+	if len(config.Brokers) == 0 {
+		return nil, fmt.Errorf("no Kafka brokers configured")
+	}
+	startOffset, err := config.startOffset()
+	if err != nil {
+		return nil, err
+	}
+	acks, err := config.acks()
+	if err != nil {
+		return nil, err
+	}
+	compression, err := config.compression()
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := config.sasl()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := config.tls()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &kafka.Dialer{
+		ClientID:      config.ClientID,
+		Timeout:       10 * time.Second,
+		DualStack:     true,
+		SASLMechanism: mechanism,
+		TLS:           tlsConfig,
+	}
 	textReader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: brokers,
-		GroupID: groupID,
-		Topic:   textTopic,
+		Brokers:     config.Brokers,
+		GroupID:     config.GroupID,
+		Topic:       config.InputTopic,
+		StartOffset: startOffset,
+		Dialer:      dialer,
+		// Коммиты копятся и отправляются раз в секунду, подтверждение не ждет брокера
+		CommitInterval: time.Second,
 	})
 
 	writer := &kafka.Writer{
-		Addr:      kafka.TCP(brokers...),
-		Topic:     writeTopic,
-		Balancer:  &kafka.LeastBytes{},
-		BatchSize: 10,
+		Addr:         kafka.TCP(config.Brokers...),
+		Topic:        config.OutputTopic,
+		Balancer:     config.balancer(),
+		BatchSize:    config.BatchSize,
+		BatchTimeout: config.BatchTimeout,
+		RequiredAcks: acks,
+		Compression:  compression,
+		Transport: &kafka.Transport{
+			ClientID: config.ClientID,
+			SASL:     mechanism,
+			TLS:      tlsConfig,
+		},
+	}
+	clusters := &kafka.Writer{
+		Addr:         writer.Addr,
+		Topic:        config.ClusterTopic,
+		Balancer:     config.balancer(),
+		BatchSize:    config.BatchSize,
+		BatchTimeout: config.BatchTimeout,
+		RequiredAcks: acks,
+		Compression:  compression,
+		Transport:    writer.Transport,
 	}
+	dlq := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Topic:        config.DLQTopic,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    writer.Transport,
+	}
+	logger.Info("Kafka configured", "brokers", config.Brokers, "group_id", config.GroupID,
+		"input_topic", config.InputTopic, "output_topic", config.OutputTopic, "cluster_topic", config.ClusterTopic, "sasl", config.SASLMechanism, "tls", config.TLS)
 
 	return &Kafka{
 		textReader:  textReader,
+		offsets:     newOffsets(),
 		textChannel: make(chan model.News, 100),
-		brokers:     brokers,
-		writeTopic:  writeTopic,
+		brokers:     config.Brokers,
+		dialer:      dialer,
+		writeTopic:  config.OutputTopic,
 		writer:      writer,
+		clusters:    clusters,
+		dlq:         dlq,
+		logger:      logger,
+	}, nil
+}
+
+var (
+	_ interfaces.Source    = (*Kafka)(nil)
+	_ interfaces.BatchSink = (*Kafka)(nil)
+	_ interfaces.BatchSink = (*clusterSink)(nil)
+)
+
+// Ping checks that at least one broker is reachable
+func (k *Kafka) Ping(ctx context.Context) error {
+	var err error
+	for _, broker := range k.brokers {
+		var conn *kafka.Conn
+		conn, err = k.dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn.Close()
+		}
 	}
+	return err
 }
 
 func (k *Kafka) TextOutput() <-chan model.News {
@@ -57,32 +157,29 @@ func (k *Kafka) StartReadingText(ctx context.Context) {
 		case <-ctx.Done(): // Завершаем чтение, если контекст отменен
 			return
 		default:
-			msg, err := k.textReader.ReadMessage(ctx)
+			msg, err := k.textReader.FetchMessage(ctx)
 			if err != nil {
+				k.logger.Warn("Error reading from Kafka", "error", err)
 				continue
 			}
-			log.Default().Println("Reading from Kafka", "data", string(msg.Value))
-			item := model.Item{}
-			err = json.Unmarshal(msg.Value, &item)
-			if err != nil {
-				continue
+			metrics.KafkaConsumed.Inc()
+			metrics.KafkaLag.Set(float64(msg.HighWaterMark - msg.Offset - 1))
+			k.logger.Debug("Reading from Kafka", "stage", "consume", "offset", msg.Offset, "data", msg.Value)
+			headers := make(map[string]string, len(msg.Headers))
+			for _, header := range msg.Headers {
+				headers[header.Key] = string(header.Value)
 			}
-			if item.Changed {
+			fetched := k.offsets.add(msg)
+			item, err := schema.DecodeItem(msg.Value, headers, msg.Time)
+			if err != nil {
+				k.deadLetter(ctx, msg, err)
+				k.commit(ctx, fetched)
 				continue
 			}
-			news := model.News{
-				MD5:         item.MD5,
-				ID:          int64(item.ID),
-				ClusterID:   0,
-				Title:       item.Title,
-				Description: item.Description,
-				FullText:    item.FullText,
-				Enclosure:   item.Enclosure,
-				PublishDate: item.PubDate.Format(time.RFC3339),
-				Embedding:   make([]float64, 0, 256),
-				IsRT:        false,
-				SourceName:  item.Name,
-				URL:         item.Link,
+			news := item.News()
+			news.Trace = headers
+			news.Ack = func() {
+				k.commit(context.WithoutCancel(ctx), fetched)
 			}
 
 			select {
@@ -94,15 +191,103 @@ func (k *Kafka) StartReadingText(ctx context.Context) {
 	}
 }
 
-func (k *Kafka) Write(data model.News) error {
-	message, err := json.Marshal(data)
+// commit marks the message processed, offsets are committed in order within a partition
+func (k *Kafka) commit(ctx context.Context, f *fetched) {
+	if err := k.offsets.done(ctx, f, k.textReader.CommitMessages); err != nil {
+		k.logger.Warn("Error committing Kafka offset", "error", err, "offset", f.msg.Offset, "partition", f.msg.Partition)
+	}
+}
+
+// deadLetter writes rejected message to the DLQ topic with the error in DLQErrorHeader
+func (k *Kafka) deadLetter(ctx context.Context, msg kafka.Message, reason error) {
+	metrics.MessagesRejected.WithLabelValues("kafka").Inc()
+	k.logger.Warn("Rejecting message", "error", reason, "offset", msg.Offset, "partition", msg.Partition)
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: DLQErrorHeader, Value: []byte(reason.Error())},
+		kafka.Header{Key: "dlq-topic", Value: []byte(msg.Topic)},
+		kafka.Header{Key: "dlq-partition", Value: []byte(strconv.Itoa(msg.Partition))},
+		kafka.Header{Key: "dlq-offset", Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+	)
+	err := k.dlq.WriteMessages(ctx, kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers})
 	if err != nil {
-		return err
+		k.logger.Error("Error writing to DLQ", "error", err, "offset", msg.Offset)
 	}
-	log.Default().Println("Writing to Kafka", "data", string(message))
-	return k.writer.WriteMessages(context.Background(), kafka.Message{
-		Key:   []byte(data.MD5), // Используем MD5 как ключ
-		Value: message,
-	})
+}
+
+// WriteMessage writes already encoded message with additional headers
+func (k *Kafka) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "kafka.write")
+	defer func() { tracing.End(span, err) }()
+
+	k.logger.Debug("Writing to Kafka", "stage", "publish", "event", event, "key", string(key), "data", message)
+	return k.write(ctx, k.writer, interfaces.Message{Event: event, Key: key, Value: message, Headers: headers})
+}
+
+// Clusters returns sink of the cluster events topic
+func (k *Kafka) Clusters() interfaces.Sink {
+	return &clusterSink{k}
+}
+
+type clusterSink struct {
+	k *Kafka
+}
+
+func (s *clusterSink) Ping(ctx context.Context) error {
+	return s.k.Ping(ctx)
+}
+
+func (s *clusterSink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "kafka.write_cluster")
+	defer func() { tracing.End(span, err) }()
+
+	s.k.logger.Debug("Writing cluster event to Kafka", "stage", "publish", "event", event, "key", string(key))
+	return s.k.write(ctx, s.k.clusters, interfaces.Message{Event: event, Key: key, Value: message, Headers: headers})
+}
+
+// WriteMessages writes messages to the output topic in one request
+func (k *Kafka) WriteMessages(ctx context.Context, messages []interfaces.Message) (err error) {
+	ctx, span := tracing.Start(ctx, "kafka.write_batch")
+	defer func() { tracing.End(span, err) }()
 
+	k.logger.Debug("Writing batch to Kafka", "stage", "publish", "messages", len(messages))
+	return k.write(ctx, k.writer, messages...)
+}
+
+func (s *clusterSink) WriteMessages(ctx context.Context, messages []interfaces.Message) (err error) {
+	ctx, span := tracing.Start(ctx, "kafka.write_cluster_batch")
+	defer func() { tracing.End(span, err) }()
+
+	s.k.logger.Debug("Writing cluster events batch to Kafka", "stage", "publish", "messages", len(messages))
+	return s.k.write(ctx, s.k.clusters, messages...)
+}
+
+func (k *Kafka) write(ctx context.Context, writer *kafka.Writer, messages ...interfaces.Message) error {
+	batch := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		carrier := map[string]string{}
+		for key, value := range message.Headers {
+			carrier[key] = value
+		}
+		tracing.Inject(ctx, carrier)
+		headers := []kafka.Header{
+			{Key: "event", Value: []byte(message.Event)},
+		}
+		for key, value := range carrier {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+		batch[i] = kafka.Message{
+			Key:     message.Key,
+			Value:   message.Value,
+			Headers: headers,
+		}
+	}
+	err := writer.WriteMessages(ctx, batch...)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		metrics.KafkaProduced.WithLabelValues(message.Event).Inc()
+	}
+	return nil
 }
@@ -0,0 +1,163 @@
+package lexical
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+)
+
+const shingleSize = 2
+
+type Scorer struct {
+	weight      float64
+	entityBoost float64
+	entityVeto  bool
+	minEntities int
+	logger      interfaces.Logger
+}
+
+// Result of comparing a news item with a cluster representative
+type Result struct {
+	Lexical  float64
+	Entities float64
+	Veto     bool
+	Distance float64
+}
+
+func New(logger interfaces.Logger) *Scorer {
+	return &Scorer{
+		weight:      percentFromEnv(logger, "LEXICAL_WEIGHT", 20),
+		entityBoost: percentFromEnv(logger, "ENTITY_BOOST", 5),
+		entityVeto:  os.Getenv("ENTITY_VETO") != "false",
+		minEntities: 2,
+		logger:      logger,
+	}
+}
+
+func percentFromEnv(logger interfaces.Logger, name string, def int) float64 {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 0 || value > 100 {
+		logger.Info("Invalid "+name+" value, using default", "default", def)
+		value = def
+	}
+	return float64(value) / 100
+}
+
+// Score mixes vector distance with lexical similarity of title and description
+// and checks that numbers and named entities of both texts do not contradict each other.
+// If the cluster has no representative text the vector distance is returned as is.
+func (s *Scorer) Score(item *model.News, cluster model.Cluster) Result {
+	result := Result{Distance: cluster.Distance}
+	clusterText := cluster.Title + " " + cluster.Description
+	if strings.TrimSpace(clusterText) == "" {
+		return result
+	}
+	itemText := item.Title + " " + item.Description
+
+	result.Lexical = jaccard(shingles(itemText), shingles(clusterText))
+
+	itemEntities := entities(itemText)
+	clusterEntities := entities(clusterText)
+	overlap, ok := overlapRatio(itemEntities, clusterEntities, s.minEntities)
+	if ok {
+		result.Entities = overlap
+		if overlap == 0 && s.entityVeto {
+			result.Veto = true
+		}
+	}
+
+	similarity := (1-s.weight)*(1-cluster.Distance) + s.weight*result.Lexical + s.entityBoost*result.Entities
+	if similarity > 1 {
+		similarity = 1
+	}
+	result.Distance = 1 - similarity
+	return result
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func shingles(text string) map[string]struct{} {
+	tokens := tokenize(strings.ToLower(text))
+	result := make(map[string]struct{})
+	if len(tokens) < shingleSize {
+		for _, token := range tokens {
+			result[token] = struct{}{}
+		}
+		return result
+	}
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		result[strings.Join(tokens[i:i+shingleSize], " ")] = struct{}{}
+	}
+	return result
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	intersection := 0
+	for key := range a {
+		if _, ok := b[key]; ok {
+			intersection++
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// entities returns numbers and capitalized words which are not at the start of a sentence
+func entities(text string) map[string]struct{} {
+	result := make(map[string]struct{})
+	sentenceStart := true
+	for _, field := range strings.Fields(text) {
+		token := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if token != "" {
+			runes := []rune(token)
+			switch {
+			case isNumber(token):
+				result[token] = struct{}{}
+			case !sentenceStart && unicode.IsUpper(runes[0]) && len(runes) > 1:
+				result[strings.ToLower(token)] = struct{}{}
+			}
+		}
+		sentenceStart = strings.ContainsAny(field[len(field)-1:], ".!?")
+	}
+	return result
+}
+
+func isNumber(token string) bool {
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// overlapRatio returns share of common elements relative to the smaller set.
+// ok is false when one of the sets is too small to judge.
+func overlapRatio(a, b map[string]struct{}, min int) (float64, bool) {
+	if len(a) < min || len(b) < min {
+		return 0, false
+	}
+	common := 0
+	for key := range a {
+		if _, ok := b[key]; ok {
+			common++
+		}
+	}
+	smaller := len(a)
+	if len(b) < smaller {
+		smaller = len(b)
+	}
+	return float64(common) / float64(smaller), true
+}
@@ -0,0 +1,100 @@
+package lexical
+
+import (
+	"math"
+	"testing"
+
+	model "agregator/group/internal/model/kafka"
+)
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		veto     bool
+		item     string
+		cluster  string
+		distance float64
+		want     Result
+	}{
+		{
+			name:     "no cluster text",
+			veto:     true,
+			item:     "Rates rose in Moscow and Kazan",
+			distance: 0.3,
+			want:     Result{Distance: 0.3},
+		},
+		{
+			name:     "same text without entities",
+			veto:     true,
+			item:     "the key rate rose today",
+			cluster:  "the key rate rose today",
+			distance: 0.3,
+			// 0.8*(1-0.3) + 0.2*1
+			want: Result{Lexical: 1, Distance: 0.24},
+		},
+		{
+			name:     "common entities boost",
+			veto:     true,
+			item:     "Talks in Moscow with Kazan today",
+			cluster:  "Meeting in Moscow with Kazan officials",
+			distance: 0.3,
+			// 0.8*(1-0.3) + 0.2*3/7 + 0.05*1
+			want: Result{Lexical: 3.0 / 7, Entities: 1, Distance: 1 - (0.56 + 0.2*3/7 + 0.05)},
+		},
+		{
+			name:     "similarity is capped",
+			veto:     true,
+			item:     "Talks in Moscow with Kazan",
+			cluster:  "Talks in Moscow with Kazan",
+			distance: 0,
+			want:     Result{Lexical: 1, Entities: 1, Distance: 0},
+		},
+		{
+			name:     "different names are vetoed",
+			veto:     true,
+			item:     "Prices rose in Moscow and Kazan",
+			cluster:  "Prices rose in Paris and Lyon",
+			distance: 0.1,
+			// 0.8*(1-0.1) + 0.2*2/8
+			want: Result{Lexical: 0.25, Veto: true, Distance: 1 - (0.72 + 0.05)},
+		},
+		{
+			name:     "different numbers are vetoed",
+			veto:     true,
+			item:     "rate raised to 16 percent in 2024",
+			cluster:  "rate raised to 15 percent in 2023",
+			distance: 0.1,
+			// 0.8*(1-0.1) + 0.2*3/9
+			want: Result{Lexical: 3.0 / 9, Veto: true, Distance: 1 - (0.72 + 0.2*3/9)},
+		},
+		{
+			name:     "veto disabled",
+			veto:     false,
+			item:     "Prices rose in Moscow and Kazan",
+			cluster:  "Prices rose in Paris and Lyon",
+			distance: 0.1,
+			want:     Result{Lexical: 0.25, Distance: 1 - (0.72 + 0.05)},
+		},
+		{
+			name:     "too few entities to judge",
+			veto:     true,
+			item:     "Prices rose in Moscow",
+			cluster:  "Prices rose in Paris",
+			distance: 0.1,
+			// 0.8*(1-0.1) + 0.2*2/4
+			want: Result{Lexical: 0.5, Distance: 1 - (0.72 + 0.1)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Scorer{weight: 0.2, entityBoost: 0.05, entityVeto: test.veto, minEntities: 2}
+			got := s.Score(&model.News{Title: test.item}, model.Cluster{Title: test.cluster, Distance: test.distance})
+			if got.Veto != test.want.Veto ||
+				math.Abs(got.Lexical-test.want.Lexical) > 1e-9 ||
+				math.Abs(got.Entities-test.want.Entities) > 1e-9 ||
+				math.Abs(got.Distance-test.want.Distance) > 1e-9 {
+				t.Errorf("Score(%q, %q) = %+v, want %+v", test.item, test.cluster, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "groupmaker"
+
+var (
+	KafkaConsumed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_consumed_total",
+		Help:      "Messages read from the input topic.",
+	})
+	KafkaLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_lag",
+		Help:      "Difference between high water mark and the last consumed offset.",
+	})
+	KafkaProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_produced_total",
+		Help:      "Messages written to the output topic by event type.",
+	}, []string{"event"})
+	MessagesRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_rejected_total",
+		Help:      "Input messages failing schema validation by transport.",
+	}, []string{"transport"})
+	TransportConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transport_messages_consumed_total",
+		Help:      "Messages read by non-Kafka sources.",
+	}, []string{"transport"})
+	TransportProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transport_messages_produced_total",
+		Help:      "Messages written by non-Kafka sinks by event type.",
+	}, []string{"transport", "event"})
+
+	StageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of item processing stages.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"stage"})
+	StageErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stage_errors_total",
+		Help:      "Failed item processing stages.",
+	}, []string{"stage"})
+
+	EmbeddingDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "embedding_request_duration_seconds",
+		Help:      "Duration of embedding API requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	EmbeddingErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embedding_errors_total",
+		Help:      "Failed embedding API requests.",
+	})
+	EmbeddingTokens = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "embedding_tokens_total",
+		Help:      "Tokens spent by embedding API.",
+	})
+
+	SearchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "search_duration_seconds",
+		Help:      "Duration of vector search requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	SearchCandidates = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "search_candidates",
+		Help:      "Number of candidate clusters returned by vector search.",
+		Buckets:   prometheus.LinearBuckets(0, 1, 16),
+	})
+	CandidateText = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "candidate_text_total",
+		Help:      "Where the text of candidate clusters for lexical scoring came from: search, store, missing.",
+	}, []string{"source"})
+
+	Assignments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "assignments_total",
+		Help:      "Cluster assignments by result: new, joined, duplicate, updated.",
+	}, []string{"result"})
+	RTChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rt_checks_total",
+		Help:      "RT checks by result.",
+	}, []string{"rt"})
+
+	OutboxPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbox_pending",
+		Help:      "Outbox entries waiting for delivery.",
+	})
+	OutboxDelivered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_delivered_total",
+		Help:      "Delivered outbox entries by kind.",
+	}, []string{"kind"})
+	OutboxErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_errors_total",
+		Help:      "Failed outbox deliveries by kind.",
+	}, []string{"kind"})
+	OutboxFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_failed_total",
+		Help:      "Outbox entries given up after the maximum number of attempts by kind.",
+	}, []string{"kind"})
+
+	WorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_busy",
+		Help:      "Workers currently processing items.",
+	})
+	WorkersLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_limit",
+		Help:      "Maximum number of concurrent workers.",
+	})
+)
+
+// RegisterDB exports connection pool stats of db. Repeated registration of the same name is ignored.
+func RegisterDB(name string, db *sql.DB) {
+	_ = prometheus.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,229 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/tracing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// IdempotencyHeader is copied into Nats-Msg-Id, so JetStream drops
+// messages published again within the stream duplicate window
+const IdempotencyHeader = "idempotency-key"
+
+// DLQErrorHeader holds the reason why the message was rejected
+const DLQErrorHeader = "dlq-error"
+
+// Nats reads news from and writes events to JetStream subjects.
+// Delivery to the service is at least once: a message is acknowledged by News.Ack after
+// processing. Messages not acknowledged within NATS_ACK_WAIT, because the process crashed
+// or the news waited and was processed longer, are redelivered by JetStream.
+type Nats struct {
+	conn          *nats.Conn
+	js            jetstream.JetStream
+	stream        string
+	durable       string
+	inputSubject  string
+	outputSubject string
+	dlqSubject    string
+	// clusterSubject receives cluster lifecycle events
+	clusterSubject string
+	ackWait        time.Duration
+	textChannel    chan model.News
+	logger         interfaces.Logger
+}
+
+var (
+	_ interfaces.Source = (*Nats)(nil)
+	_ interfaces.Sink   = (*Nats)(nil)
+)
+
+func getenv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func New(logger interfaces.Logger) (*Nats, error) {
+	conn, err := nats.Connect(getenv("NATS_URL", nats.DefaultURL), nats.Name("group-maker"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ackWait, err := time.ParseDuration(getenv("NATS_ACK_WAIT", "1m"))
+	if err != nil || ackWait <= 0 {
+		logger.Warn("Invalid NATS_ACK_WAIT value, defaulting to 1m")
+		ackWait = time.Minute
+	}
+	return &Nats{
+		conn:           conn,
+		js:             js,
+		stream:         getenv("NATS_STREAM", "news"),
+		durable:        getenv("NATS_DURABLE", "aggregator-group"),
+		inputSubject:   getenv("NATS_INPUT_SUBJECT", "group-maker"),
+		outputSubject:  getenv("NATS_OUTPUT_SUBJECT", "elastic-text-read"),
+		dlqSubject:     getenv("NATS_DLQ_SUBJECT", "group-maker.dlq"),
+		clusterSubject: getenv("NATS_CLUSTER_SUBJECT", "cluster-events"),
+		ackWait:        ackWait,
+		textChannel:    make(chan model.News, 100),
+		logger:         logger,
+	}, nil
+}
+
+func (n *Nats) Ping(ctx context.Context) error {
+	return n.conn.FlushWithContext(ctx)
+}
+
+func (n *Nats) TextOutput() <-chan model.News {
+	return n.textChannel
+}
+
+func (n *Nats) StartReadingText(ctx context.Context) {
+	defer close(n.textChannel)
+
+	consumer, err := n.js.CreateOrUpdateConsumer(ctx, n.stream, jetstream.ConsumerConfig{
+		Durable:       n.durable,
+		FilterSubject: n.inputSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       n.ackWait,
+	})
+	if err != nil {
+		n.logger.Error("Error creating NATS consumer", "error", err, "stream", n.stream)
+		return
+	}
+	messages, err := consumer.Messages()
+	if err != nil {
+		n.logger.Error("Error subscribing to NATS", "error", err, "stream", n.stream)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		messages.Stop()
+	}()
+
+	for {
+		msg, err := messages.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return
+		}
+		if err != nil {
+			n.logger.Warn("Error reading from NATS", "error", err)
+			continue
+		}
+		metrics.TransportConsumed.WithLabelValues("nats").Inc()
+		n.logger.Debug("Reading from NATS", "stage", "consume", "subject", msg.Subject(), "data", msg.Data())
+		headers := make(map[string]string, len(msg.Headers()))
+		for key := range msg.Headers() {
+			headers[key] = msg.Headers().Get(key)
+		}
+		received := time.Now()
+		if meta, err := msg.Metadata(); err == nil {
+			received = meta.Timestamp
+		}
+		item, err := schema.DecodeItem(msg.Data(), headers, received)
+		if err != nil {
+			n.deadLetter(ctx, msg, err)
+			continue
+		}
+		news := item.News()
+		news.Trace = headers
+		news.Ack = func() {
+			if err := msg.Ack(); err != nil {
+				n.logger.Warn("Error acknowledging NATS message", "error", err)
+			}
+		}
+
+		select {
+		case n.textChannel <- news:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deadLetter publishes rejected message to the DLQ subject with the error in DLQErrorHeader
+// and terminates it, so it is not redelivered
+func (n *Nats) deadLetter(ctx context.Context, msg jetstream.Msg, reason error) {
+	metrics.MessagesRejected.WithLabelValues("nats").Inc()
+	n.logger.Warn("Rejecting message", "error", reason, "subject", msg.Subject())
+	dead := nats.NewMsg(n.dlqSubject)
+	dead.Data = msg.Data()
+	for key, values := range msg.Headers() {
+		dead.Header[key] = values
+	}
+	dead.Header.Set(DLQErrorHeader, reason.Error())
+	dead.Header.Set("dlq-subject", msg.Subject())
+	if _, err := n.js.PublishMsg(ctx, dead); err != nil {
+		n.logger.Error("Error writing to DLQ", "error", err, "subject", n.dlqSubject)
+	}
+	if err := msg.Term(); err != nil {
+		n.logger.Warn("Error terminating NATS message", "error", err)
+	}
+}
+
+func (n *Nats) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "nats.write")
+	defer func() { tracing.End(span, err) }()
+
+	return n.publish(ctx, n.outputSubject, event, key, message, headers)
+}
+
+// Clusters returns sink of the cluster events subject
+func (n *Nats) Clusters() interfaces.Sink {
+	return &clusterSink{n}
+}
+
+type clusterSink struct {
+	n *Nats
+}
+
+func (s *clusterSink) Ping(ctx context.Context) error {
+	return s.n.Ping(ctx)
+}
+
+func (s *clusterSink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "nats.write_cluster")
+	defer func() { tracing.End(span, err) }()
+
+	return s.n.publish(ctx, s.n.clusterSubject, event, key, message, headers)
+}
+
+func (n *Nats) publish(ctx context.Context, subject, event string, key, message []byte, headers map[string]string) error {
+	carrier := map[string]string{}
+	for key, value := range headers {
+		carrier[key] = value
+	}
+	tracing.Inject(ctx, carrier)
+	msg := nats.NewMsg(subject)
+	msg.Data = message
+	msg.Header.Set("event", event)
+	msg.Header.Set("key", string(key))
+	for key, value := range carrier {
+		msg.Header.Set(key, value)
+	}
+	opts := []jetstream.PublishOpt{}
+	if id := carrier[IdempotencyHeader]; id != "" {
+		opts = append(opts, jetstream.WithMsgID(id))
+	}
+	n.logger.Debug("Writing to NATS", "stage", "publish", "event", event, "key", string(key), "data", message)
+	_, err := n.js.PublishMsg(ctx, msg, opts...)
+	if err != nil {
+		return err
+	}
+	metrics.TransportProduced.WithLabelValues("nats", event).Inc()
+	return nil
+}
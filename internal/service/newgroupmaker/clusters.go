@@ -0,0 +1,76 @@
+package newgroupmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/db"
+)
+
+// addClusterEvent writes cluster event to the outbox of the assignment transaction
+func (group *Group) addClusterEvent(ctx context.Context, tx db.Tx, event model.ClusterEvent) error {
+	if group.clusters == nil {
+		return nil
+	}
+	event.Time = time.Now().UTC()
+	entry, err := clusterEntry(event, fmt.Sprintf("%s:%d:%d", event.Event, event.ClusterID, event.FeedID))
+	if err != nil {
+		return err
+	}
+	return tx.AddOutbox(ctx, entry)
+}
+
+// clusterEntries fills size, RT flag, centroid, representative and summary of the clusters
+// as seen by the transaction and returns outbox entries of the events. The revision
+// identifies the change in idempotency keys, so the same change is published once.
+func (group *Group) clusterEntries(ctx context.Context, tx db.Tx, revision string, events ...model.ClusterEvent) ([]db.OutboxEntry, error) {
+	if group.clusters == nil {
+		return nil, nil
+	}
+	entries := make([]db.OutboxEntry, 0, len(events))
+	for _, event := range events {
+		if event.Event != model.EventClusterClosed {
+			cluster, err := tx.GetCluster(ctx, uint64(event.ClusterID))
+			if err != nil {
+				return nil, err
+			}
+			event.Size = cluster.NewsCount
+			event.IsRT = cluster.IsRT
+			event.RepresentativeID = cluster.RepresentativeID
+			event.Headline = cluster.Headline
+			event.Summary = cluster.Summary
+			centroid, err := tx.GetCentroid(ctx, uint64(event.ClusterID))
+			if err != nil {
+				return nil, err
+			}
+			if centroid != nil {
+				event.Centroid = centroid.GetArray()
+			}
+		}
+		event.Time = time.Now().UTC()
+		entry, err := clusterEntry(event, fmt.Sprintf("%s:%d:%s", event.Event, event.ClusterID, revision))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func clusterEntry(event model.ClusterEvent, idempotencyKey string) (db.OutboxEntry, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return db.OutboxEntry{}, err
+	}
+	return db.OutboxEntry{
+		Kind:           db.OutboxClusters,
+		Event:          event.Event,
+		Key:            strconv.FormatInt(event.ClusterID, 10),
+		Payload:        payload,
+		IdempotencyKey: idempotencyKey,
+	}, nil
+}
@@ -0,0 +1,220 @@
+package newgroupmaker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/elastic"
+	"agregator/group/service/vector"
+)
+
+// Move moves the news to another cluster by editor request
+func (group *Group) Move(ctx context.Context, feedID, target int64, actor string) error {
+	tx, err := group.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	edit, err := tx.MoveNews(ctx, uint64(feedID), uint64(target), actor)
+	if err != nil {
+		return err
+	}
+	previous := int64(edit.Previous)
+	return group.afterEdit(ctx, tx, edit, model.EditEvent{
+		Event:             model.EventArticleMoved,
+		FeedID:            feedID,
+		ClusterID:         target,
+		PreviousClusterID: previous,
+		Actor:             actor,
+	}, []int64{previous, target}, nil,
+		model.ClusterEvent{Event: model.EventClusterUpdated, ClusterID: previous, FeedID: feedID, Actor: actor},
+		model.ClusterEvent{Event: model.EventClusterUpdated, ClusterID: target, FeedID: feedID, Actor: actor})
+}
+
+// Detach moves the news into a new cluster by editor request and returns its id
+func (group *Group) Detach(ctx context.Context, feedID int64, actor string) (int64, error) {
+	tx, err := group.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	edit, err := tx.DetachNews(ctx, uint64(feedID), actor)
+	if err != nil {
+		return 0, err
+	}
+	previous, created := int64(edit.Previous), int64(edit.Cluster)
+	err = group.afterEdit(ctx, tx, edit, model.EditEvent{
+		Event:             model.EventArticleDetached,
+		FeedID:            feedID,
+		ClusterID:         created,
+		PreviousClusterID: previous,
+		Actor:             actor,
+	}, []int64{previous, created}, nil,
+		model.ClusterEvent{Event: model.EventClusterCreated, ClusterID: created, FeedID: feedID, Actor: actor},
+		model.ClusterEvent{Event: model.EventClusterUpdated, ClusterID: previous, FeedID: feedID, Actor: actor})
+	if err != nil {
+		return 0, err
+	}
+	return created, nil
+}
+
+// Merge moves all news of source cluster into target and removes source
+func (group *Group) Merge(ctx context.Context, source, target int64, actor string) ([]int64, error) {
+	tx, err := group.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Признак RT исходного кластера нужен для события закрытия, после слияния его уже нет
+	closed, err := tx.GetCluster(ctx, uint64(source))
+	if err != nil {
+		return nil, err
+	}
+	edit, err := tx.MergeClusters(ctx, uint64(source), uint64(target), actor)
+	if err != nil {
+		return nil, err
+	}
+	err = group.afterEdit(ctx, tx, edit, model.EditEvent{
+		Event:             model.EventArticleMerged,
+		ClusterID:         target,
+		PreviousClusterID: source,
+		Moved:             edit.Moved,
+		Actor:             actor,
+	}, []int64{target}, []int64{source},
+		model.ClusterEvent{Event: model.EventClusterMerged, ClusterID: target, MergedFrom: source, Actor: actor},
+		model.ClusterEvent{Event: model.EventClusterClosed, ClusterID: source, IsRT: closed.IsRT, Reason: model.CloseReasonMerged, Actor: actor})
+	if err != nil {
+		return nil, err
+	}
+	return edit.Moved, nil
+}
+
+// Pin forbids or allows automated re-clustering of the news
+func (group *Group) Pin(ctx context.Context, feedID int64, pinned bool, actor string) error {
+	tx, err := group.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	edit, err := tx.SetPinned(ctx, uint64(feedID), pinned, actor)
+	if err != nil {
+		return err
+	}
+	return group.afterEdit(ctx, tx, edit, model.EditEvent{
+		Event:     model.EventArticlePinned,
+		FeedID:    feedID,
+		ClusterID: int64(edit.Cluster),
+		Pinned:    &pinned,
+		Actor:     actor,
+	}, nil, nil)
+}
+
+// afterEdit chooses representatives of the changed clusters and writes index updates,
+// the edit event and events of changed clusters to the outbox in the transaction of
+// the edit, then commits it. Edit events go to the cluster events topic. Idempotency
+// keys include the audit log id of the edit.
+func (group *Group) afterEdit(ctx context.Context, tx db.Tx, edit db.Edit, event model.EditEvent, reindex, remove []int64, changed ...model.ClusterEvent) error {
+	revision := fmt.Sprintf("audit:%d", edit.AuditID)
+	entries := []db.OutboxEntry{}
+	for _, id := range remove {
+		entries = append(entries, removeEntry(id))
+	}
+	for _, id := range reindex {
+		_, err := group.refresh(ctx, tx, id)
+		if err != nil {
+			return fmt.Errorf("choosing representative of cluster %d: %w", id, err)
+		}
+		entry, ok, err := group.indexEntry(ctx, tx, id, fmt.Sprintf("index:%d:%s", id, revision))
+		if err != nil {
+			return fmt.Errorf("reading index document of cluster %d: %w", id, err)
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	if group.clusters != nil {
+		event.Time = time.Now().UTC()
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, db.OutboxEntry{
+			Kind:           db.OutboxClusters,
+			Event:          event.Event,
+			Key:            strconv.FormatInt(event.ClusterID, 10),
+			Payload:        payload,
+			IdempotencyKey: fmt.Sprintf("%s:%d:%s", event.Event, event.ClusterID, revision),
+		})
+	}
+	events, err := group.clusterEntries(ctx, tx, revision, changed...)
+	if err != nil {
+		return err
+	}
+	for _, entry := range append(entries, events...) {
+		err = tx.AddOutbox(ctx, entry)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, cluster := range changed {
+		if cluster.Event != model.EventClusterClosed {
+			group.scheduleSummary(cluster.ClusterID)
+		}
+	}
+	return nil
+}
+
+// indexEntry returns the outbox entry registering the cluster in the search index with
+// its current centroid, headline and representative text. Clusters without centroid
+// are not registered.
+func (group *Group) indexEntry(ctx context.Context, tx db.Tx, id int64, idempotencyKey string) (db.OutboxEntry, bool, error) {
+	doc, err := tx.GetClusterDocument(ctx, uint64(id))
+	if err != nil {
+		return db.OutboxEntry{}, false, err
+	}
+	if !doc.Embedding.Valid {
+		return db.OutboxEntry{}, false, nil
+	}
+	centroid, err := vector.FromPqString(doc.Embedding.String)
+	if err != nil {
+		return db.OutboxEntry{}, false, err
+	}
+	payload, err := json.Marshal(elastic.Document{
+		Id:          id,
+		PublishDate: doc.Time.Format(time.RFC3339),
+		Embedding:   centroid.GetArray(),
+		Title:       doc.Title.String,
+		Rewrite:     doc.FullText.String,
+		Description: doc.Description.String,
+	})
+	if err != nil {
+		return db.OutboxEntry{}, false, err
+	}
+	return db.OutboxEntry{
+		Kind:           db.OutboxIndex,
+		Key:            strconv.FormatInt(id, 10),
+		Payload:        payload,
+		IdempotencyKey: idempotencyKey,
+	}, true, nil
+}
+
+// removeEntry returns the outbox entry deleting the cluster from the search index
+func removeEntry(id int64) db.OutboxEntry {
+	payload, _ := json.Marshal(elastic.Document{Id: id})
+	return db.OutboxEntry{
+		Kind:           db.OutboxIndex,
+		Event:          db.OutboxIndexDelete,
+		Key:            strconv.FormatInt(id, 10),
+		Payload:        payload,
+		IdempotencyKey: fmt.Sprintf("index.delete:%d", id),
+	}
+}
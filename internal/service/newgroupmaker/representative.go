@@ -0,0 +1,80 @@
+package newgroupmaker
+
+import (
+	"context"
+
+	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/representative"
+	"agregator/group/service/vector"
+)
+
+// candidateLimit is how many most recent members compete with the current representative,
+// so choosing it on every join does not read the whole cluster
+const candidateLimit = 50
+
+// members is the part of db.Store and db.Tx needed to choose the representative
+type members interface {
+	GetCluster(ctx context.Context, id uint64) (db.Cluster, error)
+	GetMembers(ctx context.Context, id uint64, limit int) ([]db.Member, error)
+	SetRepresentative(ctx context.Context, id uint64, feedID int64, headline string) error
+}
+
+// choose selects the representative and the headline of the cluster among the current
+// representative, the founder and the most recent members and stores them if they changed.
+// Returns the choice, the representative member and whether it changed.
+func (group *Group) choose(ctx context.Context, store members, cluster db.Cluster, centroid *vector.Vector) (representative.Choice, db.Member, bool, error) {
+	rows, err := store.GetMembers(ctx, cluster.ID, candidateLimit)
+	if err != nil {
+		return representative.Choice{}, db.Member{}, false, err
+	}
+	candidates := make([]representative.Member, 0, len(rows))
+	for _, row := range rows {
+		member := representative.Member{
+			FeedID:      row.FeedID,
+			Title:       row.Title.String,
+			Description: row.Description.String,
+			FullText:    row.FullText.String,
+			Link:        row.Link.String,
+		}
+		if row.Embedding.Valid {
+			member.Embedding, err = vector.FromPqString(row.Embedding.String)
+			if err != nil {
+				return representative.Choice{}, db.Member{}, false, err
+			}
+		}
+		candidates = append(candidates, member)
+	}
+	choice := group.selector.Select(candidates, centroid)
+	if choice.FeedID == 0 {
+		return choice, db.Member{}, false, nil
+	}
+	var chosen db.Member
+	for _, row := range rows {
+		if row.FeedID == choice.FeedID {
+			chosen = row
+		}
+	}
+	if choice.FeedID == cluster.RepresentativeID && choice.Headline == cluster.Headline {
+		return choice, chosen, false, nil
+	}
+	err = store.SetRepresentative(ctx, cluster.ID, choice.FeedID, choice.Headline)
+	if err != nil {
+		return representative.Choice{}, db.Member{}, false, err
+	}
+	group.logger.Debug("Cluster representative changed", "cluster_id", cluster.ID, "news_id", choice.FeedID, "headline", choice.Headline)
+	return choice, chosen, true, nil
+}
+
+// refresh chooses the representative of the cluster in the transaction
+func (group *Group) refresh(ctx context.Context, tx db.Tx, id int64) (bool, error) {
+	cluster, err := tx.GetCluster(ctx, uint64(id))
+	if err != nil {
+		return false, err
+	}
+	centroid, err := tx.GetCentroid(ctx, uint64(id))
+	if err != nil {
+		return false, err
+	}
+	_, _, changed, err := group.choose(ctx, tx, cluster, centroid)
+	return changed, err
+}
@@ -1,58 +1,310 @@
 package newgroupmaker
 
 import (
+	"context"
+
+	"agregator/group/internal/interfaces"
 	model "agregator/group/internal/model/kafka"
 	"agregator/group/internal/service/db"
 	"agregator/group/internal/service/elastic"
-	"agregator/group/internal/service/kafka"
+	"agregator/group/internal/service/representative"
+	"agregator/group/internal/service/summary"
+	"agregator/group/internal/service/tracing"
 	"agregator/group/service/vector"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 )
 
 type Group struct {
-	db      *db.DB
-	kafka   *kafka.Kafka
-	elastic *elastic.Elastic
+	db       db.Store
+	clusters interfaces.Sink // nil, если события кластеров отключены
+	selector *representative.Selector
+	// summarizer откладывает пересчет сводки, пока кластер меняется
+	summarizer *summary.Summarizer
+	elastic    *elastic.Elastic
+	logger     interfaces.Logger
 }
 
-func New(db *db.DB, kafka *kafka.Kafka, elastic *elastic.Elastic) *Group {
+// New creates the group maker. All messages are written to the outbox, cluster
+// and edit events only when the clusters sink is configured.
+func New(db db.Store, clusters interfaces.Sink, selector *representative.Selector, summarizer *summary.Summarizer, elastic *elastic.Elastic, logger interfaces.Logger) *Group {
 	return &Group{
-		db:      db,
-		kafka:   kafka,
-		elastic: elastic,
+		db:         db,
+		clusters:   clusters,
+		selector:   selector,
+		summarizer: summarizer,
+		elastic:    elastic,
+		logger:     logger,
 	}
 }
 
-func (group *Group) MakeNewGroup(item *model.News) error {
-	date, err := time.Parse(time.RFC3339, item.PublishDate)
+var ErrAlreadyInserted = errors.New("failed to insert news into database: such text is already inserted")
+
+// Save stores assignment of the news in a single transaction: the new group if needed,
+// membership, decision record, parsed flag and outbox entries for the search index
+// and the output topic, which are delivered by the outbox relay after commit.
+// Joining a cluster recalculates its centroid and representative and updates the index
+// document. The summary is rebuilt after the debounce period.
+func (group *Group) Save(ctx context.Context, item *model.News, candidates []model.Candidate, newGroup bool) (err error) {
+	ctx, span := tracing.Start(ctx, "maker.save")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := group.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
 	vec := vector.New(item.Embedding)
-	id, err := group.db.Insert(date, item.ID, item.IsRT, vec)
+	if newGroup {
+		date, err := time.Parse(time.RFC3339, item.PublishDate)
+		if err != nil {
+			return err
+		}
+		id, err := tx.InsertGroup(ctx, date, item.ID, item.IsRT, vec)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return ErrAlreadyInserted
+		}
+		item.ClusterID = int64(id)
+	}
+	err = tx.InsertCompares(ctx, uint64(item.ClusterID), uint64(item.ID), vec, item.DuplicateOf)
+	if err != nil {
+		return err
+	}
+	err = tx.InsertAssignment(ctx, uint64(item.ID), uint64(item.ClusterID), candidates, item.Decision)
 	if err != nil {
 		return err
 	}
-	if id == 0 {
-		return fmt.Errorf("failed to insert news into database: such text is already inserted")
+	err = tx.UpdateParsed(ctx, uint64(item.ID), true)
+	if err != nil {
+		return err
 	}
-	item.ClusterID = int64(id)
-	err = group.elastic.RegisterClusert(int64(id), item.PublishDate, item.Embedding, item.Title, item.FullText, item.Description)
+
+	event := model.ClusterEvent{
+		Event:            model.EventClusterCreated,
+		ClusterID:        item.ClusterID,
+		FeedID:           item.ID,
+		Size:             1,
+		IsRT:             item.IsRT,
+		Centroid:         item.Embedding,
+		RepresentativeID: item.ID,
+		Headline:         item.Title,
+	}
+	if !newGroup {
+		// Дубликат повторяет эмбеддинг оригинала и не сдвигает центроид
+		var centroid *vector.Vector
+		if item.IsDuplicate {
+			centroid, err = tx.GetCentroid(ctx, uint64(item.ClusterID))
+		} else {
+			centroid, err = tx.JoinCentroid(ctx, uint64(item.ClusterID), vec)
+		}
+		if err != nil {
+			return err
+		}
+		cluster, err := tx.GetCluster(ctx, uint64(item.ClusterID))
+		if err != nil {
+			return err
+		}
+		choice, chosen, _, err := group.choose(ctx, tx, cluster, centroid)
+		if err != nil {
+			return err
+		}
+		event.Event = model.EventClusterUpdated
+		event.Size = cluster.NewsCount
+		event.IsRT = cluster.IsRT
+		event.Centroid = nil
+		if centroid != nil {
+			event.Centroid = centroid.GetArray()
+		}
+		event.RepresentativeID = choice.FeedID
+		event.Headline = choice.Headline
+		event.Summary = cluster.Summary
+		// Центроид сдвигается при каждом присоединении, поэтому документ индекса
+		// обновляется всегда, а не только при смене представителя
+		if centroid != nil {
+			err = group.addIndex(ctx, tx, item, elastic.Document{
+				Id:          item.ClusterID,
+				PublishDate: cluster.Time.Format(time.RFC3339),
+				Embedding:   centroid.GetArray(),
+				Title:       choice.Headline,
+				Rewrite:     chosen.FullText.String,
+				Description: chosen.Description.String,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = group.addClusterEvent(ctx, tx, event)
 	if err != nil {
 		return err
 	}
+
+	if newGroup {
+		err = group.addIndex(ctx, tx, item, elastic.Document{
+			Id:          item.ClusterID,
+			PublishDate: item.PublishDate,
+			Embedding:   item.Embedding,
+			Title:       item.Title,
+			Rewrite:     item.FullText,
+			Description: item.Description,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	message, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	tracing.Inject(ctx, headers)
+	err = tx.AddOutbox(ctx, db.OutboxEntry{
+		Kind:  db.OutboxKafka,
+		Event: model.EventArticleCreated,
+		// Все события статьи идут с ее id в ключе, поэтому сохраняют порядок
+		Key:            strconv.FormatInt(item.ID, 10),
+		Payload:        message,
+		Headers:        headers,
+		IdempotencyKey: fmt.Sprintf("%s:%d", model.EventArticleCreated, item.ID),
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	group.scheduleSummary(item.ClusterID)
 	return nil
 }
 
-func (group *Group) SaveNews(item model.News) error {
-	err := group.db.InsertCompares(uint64(item.ClusterID), uint64(item.ID))
+// addIndex writes the search index document of the cluster changed by the news to the outbox
+func (group *Group) addIndex(ctx context.Context, tx db.Tx, item *model.News, doc elastic.Document) error {
+	payload, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return tx.AddOutbox(ctx, db.OutboxEntry{
+		Kind:           db.OutboxIndex,
+		Key:            strconv.FormatInt(item.ClusterID, 10),
+		Payload:        payload,
+		IdempotencyKey: fmt.Sprintf("index:%d:%d", item.ClusterID, item.ID),
+	})
+}
+
+func (group *Group) SaveNews(ctx context.Context, item model.News) (err error) {
+	ctx, span := tracing.Start(ctx, "maker.save_news")
+	defer func() { tracing.End(span, err) }()
+
+	return group.Save(ctx, &item, nil, false)
+}
+
+// CurrentCluster returns membership of already clustered news
+func (group *Group) CurrentCluster(ctx context.Context, newsID int64) (db.Membership, error) {
+	return group.db.GetGroupByFeed(ctx, uint64(newsID))
+}
+
+// UpdateNews stores the edited news in item.ClusterID, or in a new cluster founded by it
+// if newGroup is set, in a single transaction: membership, centroids and representatives
+// of the new and the previous cluster, index documents of both and article.updated and
+// cluster events in the outbox. The summaries are rebuilt after the debounce period.
+func (group *Group) UpdateNews(ctx context.Context, item *model.News, previous int64, newGroup bool) (err error) {
+	ctx, span := tracing.Start(ctx, "maker.update_news")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := group.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	err = group.db.UpdateParsed(uint64(item.ID), true)
+	defer tx.Rollback()
+
+	vec := vector.New(item.Embedding)
+	changed := []model.ClusterEvent{{Event: model.EventClusterUpdated, ClusterID: item.ClusterID, FeedID: item.ID}}
+	if newGroup {
+		date, err := time.Parse(time.RFC3339, item.PublishDate)
+		if err != nil {
+			return err
+		}
+		id, err := tx.InsertGroup(ctx, date, item.ID, item.IsRT, vec)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			return ErrAlreadyInserted
+		}
+		item.ClusterID = int64(id)
+		changed[0] = model.ClusterEvent{Event: model.EventClusterCreated, ClusterID: item.ClusterID, FeedID: item.ID}
+	}
+	err = tx.MoveCompare(ctx, uint64(item.ID), uint64(item.ClusterID), vec)
 	if err != nil {
 		return err
 	}
-	return group.kafka.Write(item)
+	if previous != item.ClusterID {
+		changed = append(changed, model.ClusterEvent{Event: model.EventClusterUpdated, ClusterID: previous, FeedID: item.ID})
+	}
+
+	// Редакция статьи определяется ее MD5, повторная обработка той же правки
+	// не создает новых записей outbox
+	revision := fmt.Sprintf("%d:%s", item.ID, item.MD5)
+	// Центроиды обоих кластеров сдвинулись, поэтому индекс обновляется всегда,
+	// а не только при смене представителя
+	entries := []db.OutboxEntry{}
+	for _, event := range changed {
+		_, err = tx.UpdateCentroid(ctx, uint64(event.ClusterID))
+		if err != nil {
+			return err
+		}
+		_, err = group.refresh(ctx, tx, event.ClusterID)
+		if err != nil {
+			return err
+		}
+		entry, ok, err := group.indexEntry(ctx, tx, event.ClusterID, fmt.Sprintf("index:%d:%s", event.ClusterID, revision))
+		if err != nil {
+			return err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	message, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	tracing.Inject(ctx, headers)
+	// article.updated идет через outbox, чтобы не обогнать еще не доставленный article.created
+	entries = append(entries, db.OutboxEntry{
+		Kind:           db.OutboxKafka,
+		Event:          model.EventArticleUpdated,
+		Key:            strconv.FormatInt(item.ID, 10),
+		Payload:        message,
+		Headers:        headers,
+		IdempotencyKey: fmt.Sprintf("%s:%s", model.EventArticleUpdated, revision),
+	})
+	events, err := group.clusterEntries(ctx, tx, revision, changed...)
+	if err != nil {
+		return err
+	}
+	for _, entry := range append(entries, events...) {
+		err = tx.AddOutbox(ctx, entry)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, event := range changed {
+		group.scheduleSummary(event.ClusterID)
+	}
+	return nil
 }
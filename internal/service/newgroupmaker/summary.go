@@ -0,0 +1,104 @@
+package newgroupmaker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+)
+
+// summaryMembers is how many most recent members, besides the founder and the representative,
+// are summarized, it bounds the cost of summaries of large clusters
+const summaryMembers = 20
+
+// scheduleSummary requests recalculation of the cluster summary after membership change
+func (group *Group) scheduleSummary(id int64) {
+	group.summarizer.Schedule(id)
+}
+
+// RunSummaries rebuilds summaries of changed clusters when their debounce period is over
+func (group *Group) RunSummaries(ctx context.Context) {
+	ticker := time.NewTicker(group.summarizer.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			group.summarize(ctx, group.summarizer.Due(now))
+		}
+	}
+}
+
+// FlushSummaries rebuilds summaries of all changed clusters without waiting for debounce
+func (group *Group) FlushSummaries(ctx context.Context) {
+	group.summarize(ctx, group.summarizer.Due(time.Now().Add(24*time.Hour)))
+}
+
+func (group *Group) summarize(ctx context.Context, ids []int64) {
+	for _, id := range ids {
+		err := group.updateSummary(ctx, id)
+		if err != nil {
+			group.logger.Error("Error updating cluster summary", "error", err, "cluster_id", id)
+		}
+	}
+}
+
+// updateSummary stores the summary of the member texts and writes cluster.updated
+// to the outbox in one transaction if the summary changed. Clusters removed in the
+// meantime are skipped.
+func (group *Group) updateSummary(ctx context.Context, id int64) error {
+	cluster, err := group.db.GetCluster(ctx, uint64(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	members, err := group.db.GetMembers(ctx, uint64(id), summaryMembers)
+	if err != nil {
+		return err
+	}
+	texts := make([]string, 0, len(members))
+	for _, member := range members {
+		// Описание обычно является лидом статьи, поэтому оно идет перед полным текстом
+		texts = append(texts, strings.TrimSpace(member.Description.String+"\n"+member.FullText.String))
+	}
+	summary := group.summarizer.Summarize(texts)
+	if summary == cluster.Summary {
+		return nil
+	}
+
+	tx, err := group.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	version, err := tx.SetSummary(ctx, uint64(id), summary)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := group.clusterEntries(ctx, tx, fmt.Sprintf("summary:%d", version), model.ClusterEvent{Event: model.EventClusterUpdated, ClusterID: id})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = tx.AddOutbox(ctx, entry)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	group.logger.Debug("Cluster summary changed", "cluster_id", id)
+	return nil
+}
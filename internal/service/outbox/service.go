@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/db"
+	"agregator/group/internal/service/elastic"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/transport"
+)
+
+const (
+	batchSize       = 100
+	pollInterval    = 2 * time.Second
+	maxBackoff      = 5 * time.Minute
+	cleanupInterval = 10 * time.Minute
+
+	// IdempotencyHeader lets consumers drop messages delivered more than once
+	IdempotencyHeader = "idempotency-key"
+)
+
+// Relay delivers outbox entries to Kafka, the cluster events topic and the search index.
+// Delivery is at least once: an entry is marked delivered after the side effect succeeded.
+type Relay struct {
+	db       db.Outbox
+	sink     interfaces.Sink
+	clusters interfaces.Sink // nil, если события кластеров отключены
+	elastic  *elastic.Elastic
+	logger   interfaces.Logger
+	notify   chan struct{}
+	// retention is how long delivered entries are kept to drop repeated side effects
+	retention time.Duration
+	// maxAttempts is how many times an entry is tried before it is marked failed
+	maxAttempts int
+	cleanupMu   sync.Mutex
+	lastCleanup time.Time
+}
+
+// New reads OUTBOX_RETENTION, how long delivered entries are kept (24h by default),
+// and OUTBOX_MAX_ATTEMPTS, how many times an entry is tried before it is marked
+// failed and stops holding back its key (20 by default)
+func New(db db.Outbox, sink, clusters interfaces.Sink, elastic *elastic.Elastic, logger interfaces.Logger) *Relay {
+	retention := 24 * time.Hour
+	if value := os.Getenv("OUTBOX_RETENTION"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			logger.Warn("Invalid OUTBOX_RETENTION value, defaulting to 24h", "value", value)
+		} else {
+			retention = parsed
+		}
+	}
+	maxAttempts := 20
+	if value := os.Getenv("OUTBOX_MAX_ATTEMPTS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			logger.Warn("Invalid OUTBOX_MAX_ATTEMPTS value, defaulting to 20", "value", value)
+		} else {
+			maxAttempts = parsed
+		}
+	}
+	return &Relay{
+		db:          db,
+		sink:        sink,
+		clusters:    clusters,
+		elastic:     elastic,
+		logger:      logger,
+		notify:      make(chan struct{}, 1),
+		retention:   retention,
+		maxAttempts: maxAttempts,
+		lastCleanup: time.Now(),
+	}
+}
+
+// Notify wakes the relay up without waiting for the next poll
+func (r *Relay) Notify() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.notify:
+		}
+		r.Flush(ctx)
+	}
+}
+
+// Flush delivers all entries which are due now
+func (r *Relay) Flush(ctx context.Context) {
+	// Разбираем очередь пачками, пока есть что доставлять
+	for {
+		delivered, err := r.db.ProcessOutbox(ctx, batchSize, r.maxAttempts, backoff, r.deliver)
+		if err != nil {
+			r.logger.Error("Error processing outbox", "error", err)
+			break
+		}
+		if delivered < batchSize {
+			break
+		}
+	}
+	if pending, err := r.db.PendingOutbox(ctx); err == nil {
+		metrics.OutboxPending.Set(float64(pending))
+	}
+	r.cleanup(ctx)
+}
+
+// cleanup deletes entries delivered earlier than the retention period once in cleanupInterval
+func (r *Relay) cleanup(ctx context.Context) {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+	if time.Since(r.lastCleanup) < cleanupInterval {
+		return
+	}
+	r.lastCleanup = time.Now()
+	deleted, err := r.db.DeleteDelivered(ctx, r.lastCleanup.Add(-r.retention))
+	if err != nil {
+		r.logger.Error("Error deleting delivered outbox entries", "error", err)
+		return
+	}
+	if deleted > 0 {
+		r.logger.Debug("Deleted delivered outbox entries", "entries", deleted)
+	}
+}
+
+func backoff(attempts int) time.Duration {
+	delay := time.Duration(math.Pow(2, float64(attempts))) * time.Second
+	return min(delay, maxBackoff)
+}
+
+// deliver writes Kafka and cluster entries in one batch per sink and registers
+// or deletes index documents one by one. Once an entry fails, later entries of its key are
+// returned as blocked, so they are retried after it and in order.
+func (r *Relay) deliver(ctx context.Context, entries []db.OutboxEntry) []error {
+	results := make([]error, len(entries))
+	byKind := map[string][]int{}
+	for i, entry := range entries {
+		byKind[entry.Kind] = append(byKind[entry.Kind], i)
+	}
+	for kind, batch := range byKind {
+		switch kind {
+		case db.OutboxKafka:
+			r.writeBatch(ctx, r.sink, entries, batch, results)
+		case db.OutboxClusters:
+			if r.clusters == nil {
+				// События кластеров отключены после записи в outbox, отбрасываем
+				r.logger.Debug("Dropping cluster events", "entries", len(batch))
+				continue
+			}
+			r.writeBatch(ctx, r.clusters, entries, batch, results)
+		case db.OutboxIndex:
+			failed := map[string]bool{}
+			for _, i := range batch {
+				if blocked(failed, entries[i]) {
+					results[i] = db.ErrOutboxBlocked
+					continue
+				}
+				var doc elastic.Document
+				err := json.Unmarshal(entries[i].Payload, &doc)
+				switch {
+				case err != nil:
+				case entries[i].Event == db.OutboxIndexDelete:
+					err = r.elastic.DeleteCluster(ctx, doc.Id)
+				default:
+					err = r.elastic.RegisterDocument(ctx, doc)
+				}
+				if err != nil {
+					failed[entries[i].Key] = true
+				}
+				results[i] = err
+			}
+		default:
+			for _, i := range batch {
+				results[i] = fmt.Errorf("unknown outbox entry kind %q", kind)
+			}
+		}
+	}
+	for i, entry := range entries {
+		switch {
+		case results[i] == nil:
+			metrics.OutboxDelivered.WithLabelValues(entry.Kind).Inc()
+		case errors.Is(results[i], db.ErrOutboxBlocked):
+		case entry.Attempts+1 >= r.maxAttempts:
+			metrics.OutboxErrors.WithLabelValues(entry.Kind).Inc()
+			metrics.OutboxFailed.WithLabelValues(entry.Kind).Inc()
+			r.logger.Error("Outbox entry failed, giving up", "error", results[i], "kind", entry.Kind, "key", entry.IdempotencyKey, "attempts", entry.Attempts+1)
+		default:
+			metrics.OutboxErrors.WithLabelValues(entry.Kind).Inc()
+			r.logger.Warn("Error delivering outbox entry", "error", results[i], "kind", entry.Kind, "key", entry.IdempotencyKey, "attempts", entry.Attempts+1)
+		}
+	}
+	return results
+}
+
+// writeBatch writes the entries to the sink in one request. If the request fails,
+// entries are written one by one to find the failing ones, consumers drop
+// repeated messages by the idempotency-key header.
+func (r *Relay) writeBatch(ctx context.Context, sink interfaces.Sink, entries []db.OutboxEntry, batch []int, results []error) {
+	messages := make([]interfaces.Message, len(batch))
+	for n, i := range batch {
+		messages[n] = message(entries[i])
+	}
+	err := transport.WriteMessages(ctx, sink, messages)
+	if err == nil {
+		return
+	}
+	r.logger.Warn("Error writing outbox batch, retrying one by one", "error", err, "entries", len(batch))
+	failed := map[string]bool{}
+	for n, i := range batch {
+		if blocked(failed, entries[i]) {
+			results[i] = db.ErrOutboxBlocked
+			continue
+		}
+		results[i] = sink.WriteMessage(ctx, messages[n].Event, messages[n].Key, messages[n].Value, messages[n].Headers)
+		if results[i] != nil {
+			failed[entries[i].Key] = true
+		}
+	}
+}
+
+// blocked reports whether an earlier entry of the key failed, entries without key are not ordered
+func blocked(failed map[string]bool, entry db.OutboxEntry) bool {
+	return entry.Key != "" && failed[entry.Key]
+}
+
+func message(entry db.OutboxEntry) interfaces.Message {
+	headers := map[string]string{IdempotencyHeader: entry.IdempotencyKey}
+	for key, value := range entry.Headers {
+		headers[key] = value
+	}
+	return interfaces.Message{Event: entry.Event, Key: []byte(entry.Key), Value: entry.Payload, Headers: headers}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/tracing"
+)
+
+type Middleware func(Stage) Stage
+
+// Recovery turns panic of a stage into an error so one bad item can't crash the worker
+func Recovery(logger interfaces.Logger) Middleware {
+	return func(next Stage) Stage {
+		return NewStage(next.Name(), func(ctx context.Context, item *Item) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Error("Panic in stage", "stage", next.Name(), "news_id", item.News.ID, "panic", r, "stack", string(debug.Stack()))
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return next.Process(ctx, item)
+		})
+	}
+}
+
+// Timing logs duration of every stage
+func Timing(logger interfaces.Logger) Middleware {
+	return func(next Stage) Stage {
+		return NewStage(next.Name(), func(ctx context.Context, item *Item) error {
+			start := time.Now()
+			err := next.Process(ctx, item)
+			logger.Debug("Stage finished", "stage", next.Name(), "news_id", item.News.ID, "duration", time.Since(start))
+			return err
+		})
+	}
+}
+
+// Logging logs stage failures
+func Logging(logger interfaces.Logger) Middleware {
+	return func(next Stage) Stage {
+		return NewStage(next.Name(), func(ctx context.Context, item *Item) error {
+			err := next.Process(ctx, item)
+			if err != nil && err != ErrStop {
+				logger.Error("Stage failed", "stage", next.Name(), "news_id", item.News.ID, "error", err)
+			}
+			return err
+		})
+	}
+}
+
+// Metrics exports duration and errors of every stage
+func Metrics() Middleware {
+	return func(next Stage) Stage {
+		return NewStage(next.Name(), func(ctx context.Context, item *Item) error {
+			start := time.Now()
+			err := next.Process(ctx, item)
+			metrics.StageDuration.WithLabelValues(next.Name()).Observe(time.Since(start).Seconds())
+			if err != nil && err != ErrStop {
+				metrics.StageErrors.WithLabelValues(next.Name()).Inc()
+			}
+			return err
+		})
+	}
+}
+
+// Tracing starts a span for every stage
+func Tracing() Middleware {
+	return func(next Stage) Stage {
+		return NewStage(next.Name(), func(ctx context.Context, item *Item) error {
+			ctx, span := tracing.Start(ctx, "stage."+next.Name())
+			err := next.Process(ctx, item)
+			if err == ErrStop {
+				tracing.End(span, nil)
+			} else {
+				tracing.End(span, err)
+			}
+			return err
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/service/vector"
+)
+
+// ErrStop is returned by a stage which finished processing of the item.
+// Remaining stages are skipped and the item is not treated as failed.
+var ErrStop = errors.New("pipeline stopped")
+
+// Item is the state passed between stages
+type Item struct {
+	News       model.News
+	Embedding  *vector.Vector
+	Similars   []model.Cluster
+	Candidates []model.Candidate
+	Found      bool
+	Hash       uint64
+	Hashed     bool
+}
+
+type Stage interface {
+	Name() string
+	Process(ctx context.Context, item *Item) error
+}
+
+type stageFunc struct {
+	name string
+	fn   func(ctx context.Context, item *Item) error
+}
+
+func (s *stageFunc) Name() string {
+	return s.name
+}
+
+func (s *stageFunc) Process(ctx context.Context, item *Item) error {
+	return s.fn(ctx, item)
+}
+
+// NewStage returns stage with given name calling fn
+func NewStage(name string, fn func(ctx context.Context, item *Item) error) Stage {
+	return &stageFunc{name: name, fn: fn}
+}
+
+type Pipeline struct {
+	stages      []Stage
+	middlewares []Middleware
+	logger      interfaces.Logger
+}
+
+func New(logger interfaces.Logger, stages ...Stage) *Pipeline {
+	return &Pipeline{
+		stages: stages,
+		logger: logger,
+	}
+}
+
+// Use adds middlewares wrapping every stage. The first added middleware is the outermost.
+func (p *Pipeline) Use(middlewares ...Middleware) {
+	p.middlewares = append(p.middlewares, middlewares...)
+}
+
+// Stages returns names of stages in execution order
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name()
+	}
+	return names
+}
+
+func (p *Pipeline) index(name string) int {
+	for i, stage := range p.stages {
+		if stage.Name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Pipeline) insert(i int, stage Stage) {
+	p.stages = append(p.stages, nil)
+	copy(p.stages[i+1:], p.stages[i:])
+	p.stages[i] = stage
+}
+
+func (p *Pipeline) InsertBefore(name string, stage Stage) error {
+	i := p.index(name)
+	if i < 0 {
+		return fmt.Errorf("stage %q not found", name)
+	}
+	p.insert(i, stage)
+	return nil
+}
+
+func (p *Pipeline) InsertAfter(name string, stage Stage) error {
+	i := p.index(name)
+	if i < 0 {
+		return fmt.Errorf("stage %q not found", name)
+	}
+	p.insert(i+1, stage)
+	return nil
+}
+
+// Without returns copy of the pipeline without given stages
+func (p *Pipeline) Without(names ...string) *Pipeline {
+	result := &Pipeline{
+		middlewares: p.middlewares,
+		logger:      p.logger,
+	}
+	for _, stage := range p.stages {
+		skip := false
+		for _, name := range names {
+			if stage.Name() == name {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			result.stages = append(result.stages, stage)
+		}
+	}
+	return result
+}
+
+func (p *Pipeline) wrap(stage Stage) Stage {
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		stage = p.middlewares[i](stage)
+	}
+	return stage
+}
+
+// Run passes item through all stages. A panic in any stage is returned as error.
+func (p *Pipeline) Run(ctx context.Context, item *Item) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in pipeline: %v", r)
+		}
+	}()
+
+	for _, stage := range p.stages {
+		err := p.wrap(stage).Process(ctx, item)
+		if errors.Is(err, ErrStop) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", stage.Name(), err)
+		}
+	}
+	return nil
+}
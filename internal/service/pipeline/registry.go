@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"agregator/group/internal/interfaces"
+)
+
+type Factory func(logger interfaces.Logger) Stage
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		"skip_empty": skipEmpty,
+		"trim":       trim,
+	}
+)
+
+// Register makes custom stage available for configuration
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// Configure inserts custom stages described by spec into the pipeline.
+// Spec is a comma separated list of "name:before|after:stage", e.g. "skip_empty:before:embed".
+func (p *Pipeline) Configure(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid stage spec %q", entry)
+		}
+		registryMu.RLock()
+		factory, ok := registry[parts[0]]
+		registryMu.RUnlock()
+		if !ok {
+			return fmt.Errorf("unknown stage %q", parts[0])
+		}
+
+		stage := factory(p.logger)
+		var err error
+		switch parts[1] {
+		case "before":
+			err = p.InsertBefore(parts[2], stage)
+		case "after":
+			err = p.InsertAfter(parts[2], stage)
+		default:
+			err = fmt.Errorf("invalid position %q in stage spec %q", parts[1], entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipEmpty(logger interfaces.Logger) Stage {
+	return NewStage("skip_empty", func(ctx context.Context, item *Item) error {
+		if strings.TrimSpace(item.News.Title+item.News.Description+item.News.FullText) == "" {
+			logger.Info("Skipping empty news", "news_id", item.News.ID)
+			return ErrStop
+		}
+		return nil
+	})
+}
+
+func trim(logger interfaces.Logger) Stage {
+	return NewStage("trim", func(ctx context.Context, item *Item) error {
+		item.News.Title = strings.TrimSpace(item.News.Title)
+		item.News.Description = strings.TrimSpace(item.News.Description)
+		item.News.FullText = strings.TrimSpace(item.News.FullText)
+		return nil
+	})
+}
@@ -0,0 +1,307 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/schema"
+	"agregator/group/internal/service/tracing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Поля записи потока: полезная нагрузка, ключ, тип события и заголовки с префиксом
+	dataField    = "data"
+	keyField     = "key"
+	eventField   = "event"
+	headerPrefix = "header:"
+	errorField   = "dlq-error"
+
+	readCount  = 50
+	readBlock  = 5 * time.Second
+	retryDelay = time.Second
+)
+
+// Redis reads news from and writes events to Redis Streams.
+// Delivery to the service is at least once: an entry is acknowledged by News.Ack after
+// processing. Entries left pending by a crashed consumer, or processed longer than
+// REDIS_CLAIM_IDLE, are reclaimed and processed again.
+type Redis struct {
+	client       *redis.Client
+	group        string
+	consumer     string
+	inputStream  string
+	outputStream string
+	dlqStream    string
+	// clusterStream receives cluster lifecycle events
+	clusterStream string
+	maxLen        int64
+	claimIdle     time.Duration
+	textChannel   chan model.News
+	logger        interfaces.Logger
+}
+
+var (
+	_ interfaces.Source = (*Redis)(nil)
+	_ interfaces.Sink   = (*Redis)(nil)
+)
+
+func getenv(name, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func New(logger interfaces.Logger) *Redis {
+	db, err := strconv.Atoi(os.Getenv("REDIS_DB"))
+	if err != nil {
+		db = 0
+	}
+	maxLen, err := strconv.ParseInt(os.Getenv("REDIS_MAXLEN"), 10, 64)
+	if err != nil {
+		maxLen = 0
+	}
+	claimIdle, err := time.ParseDuration(getenv("REDIS_CLAIM_IDLE", "1m"))
+	if err != nil {
+		logger.Warn("Invalid REDIS_CLAIM_IDLE value, defaulting to 1m")
+		claimIdle = time.Minute
+	}
+	hostname, _ := os.Hostname()
+	return &Redis{
+		client: redis.NewClient(&redis.Options{
+			Addr:     getenv("REDIS_ADDR", "localhost:6379"),
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       db,
+		}),
+		group:         getenv("REDIS_GROUP", "aggregator-group"),
+		consumer:      getenv("REDIS_CONSUMER", hostname),
+		inputStream:   getenv("REDIS_INPUT_STREAM", "group-maker"),
+		outputStream:  getenv("REDIS_OUTPUT_STREAM", "elastic-text-read"),
+		dlqStream:     getenv("REDIS_DLQ_STREAM", "group-maker-dlq"),
+		clusterStream: getenv("REDIS_CLUSTER_STREAM", "cluster-events"),
+		maxLen:        maxLen,
+		claimIdle:     claimIdle,
+		textChannel:   make(chan model.News, 100),
+		logger:        logger,
+	}
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *Redis) TextOutput() <-chan model.News {
+	return r.textChannel
+}
+
+func (r *Redis) StartReadingText(ctx context.Context) {
+	defer close(r.textChannel)
+
+	err := r.client.XGroupCreateMkStream(ctx, r.inputStream, r.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		r.logger.Error("Error creating Redis consumer group", "error", err, "stream", r.inputStream)
+		return
+	}
+
+	if !r.reclaim(ctx) {
+		return
+	}
+	for {
+		streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.group,
+			Consumer: r.consumer,
+			Streams:  []string{r.inputStream, ">"},
+			Count:    readCount,
+			Block:    readBlock,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, redis.Nil) {
+			// Поток простаивает, подбираем записи упавших потребителей
+			if !r.reclaim(ctx) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			r.logger.Warn("Error reading from Redis", "error", err)
+			select {
+			case <-time.After(retryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				if !r.handle(ctx, message) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// reclaim takes over entries of the group pending longer than claimIdle, which were
+// read by a consumer that stopped before handing them over. False means that ctx is done.
+func (r *Redis) reclaim(ctx context.Context) bool {
+	start := "0-0"
+	for {
+		messages, next, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   r.inputStream,
+			Group:    r.group,
+			Consumer: r.consumer,
+			MinIdle:  r.claimIdle,
+			Start:    start,
+			Count:    readCount,
+		}).Result()
+		if ctx.Err() != nil {
+			return false
+		}
+		if err != nil {
+			r.logger.Warn("Error reclaiming Redis entries", "error", err)
+			return true
+		}
+		if len(messages) > 0 {
+			r.logger.Info("Reclaimed pending Redis entries", "entries", len(messages))
+		}
+		for _, message := range messages {
+			if !r.handle(ctx, message) {
+				return false
+			}
+		}
+		if next == "0-0" || next == "" {
+			return true
+		}
+		start = next
+	}
+}
+
+// handle sends the message to the channel, it is acknowledged by News.Ack.
+// False means that ctx is done.
+func (r *Redis) handle(ctx context.Context, message redis.XMessage) bool {
+	metrics.TransportConsumed.WithLabelValues("redis").Inc()
+	data, _ := message.Values[dataField].(string)
+	r.logger.Debug("Reading from Redis", "stage", "consume", "id", message.ID, "data", data)
+	headers := map[string]string{}
+	for field, value := range message.Values {
+		if name, ok := strings.CutPrefix(field, headerPrefix); ok {
+			headers[name], _ = value.(string)
+		}
+	}
+	item, err := schema.DecodeItem([]byte(data), headers, entryTime(message.ID))
+	if err != nil {
+		r.deadLetter(ctx, message, err)
+		r.ack(ctx, message.ID)
+		return true
+	}
+	news := item.News()
+	news.Trace = headers
+	news.Ack = func() {
+		r.ack(context.WithoutCancel(ctx), message.ID)
+	}
+
+	select {
+	case r.textChannel <- news:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// entryTime returns the time encoded in the stream entry id
+func entryTime(id string) time.Time {
+	millis, _, _ := strings.Cut(id, "-")
+	value, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.UnixMilli(value)
+}
+
+// deadLetter adds rejected entry to the DLQ stream with the error in errorField
+func (r *Redis) deadLetter(ctx context.Context, message redis.XMessage, reason error) {
+	metrics.MessagesRejected.WithLabelValues("redis").Inc()
+	r.logger.Warn("Rejecting message", "error", reason, "id", message.ID)
+	values := make(map[string]any, len(message.Values)+2)
+	for field, value := range message.Values {
+		values[field] = value
+	}
+	values[errorField] = reason.Error()
+	values["dlq-id"] = message.ID
+	err := r.client.XAdd(ctx, &redis.XAddArgs{Stream: r.dlqStream, Values: values}).Err()
+	if err != nil {
+		r.logger.Error("Error writing to DLQ", "error", err, "stream", r.dlqStream)
+	}
+}
+
+func (r *Redis) ack(ctx context.Context, id string) {
+	if err := r.client.XAck(ctx, r.inputStream, r.group, id).Err(); err != nil {
+		r.logger.Warn("Error acknowledging Redis message", "error", err, "id", id)
+	}
+}
+
+func (r *Redis) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "redis.write")
+	defer func() { tracing.End(span, err) }()
+
+	return r.add(ctx, r.outputStream, event, key, message, headers)
+}
+
+// Clusters returns sink of the cluster events stream
+func (r *Redis) Clusters() interfaces.Sink {
+	return &clusterSink{r}
+}
+
+type clusterSink struct {
+	r *Redis
+}
+
+func (s *clusterSink) Ping(ctx context.Context) error {
+	return s.r.Ping(ctx)
+}
+
+func (s *clusterSink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) (err error) {
+	ctx, span := tracing.Start(ctx, "redis.write_cluster")
+	defer func() { tracing.End(span, err) }()
+
+	return s.r.add(ctx, s.r.clusterStream, event, key, message, headers)
+}
+
+func (r *Redis) add(ctx context.Context, stream, event string, key, message []byte, headers map[string]string) error {
+	carrier := map[string]string{}
+	for key, value := range headers {
+		carrier[key] = value
+	}
+	tracing.Inject(ctx, carrier)
+	values := map[string]any{
+		dataField:  message,
+		keyField:   key,
+		eventField: event,
+	}
+	for name, value := range carrier {
+		values[headerPrefix+name] = value
+	}
+	r.logger.Debug("Writing to Redis", "stage", "publish", "event", event, "key", string(key), "data", message)
+	err := r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: r.maxLen,
+		Approx: r.maxLen > 0,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return err
+	}
+	metrics.TransportProduced.WithLabelValues("redis", event).Inc()
+	return nil
+}
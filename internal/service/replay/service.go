@@ -0,0 +1,210 @@
+package replay
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/metrics"
+	"agregator/group/internal/service/schema"
+)
+
+const maxLine = 16 << 20
+
+type Options struct {
+	// Rate limits items per second, 0 means as fast as possible
+	Rate float64
+	// Clock sorts items by PubDate and releases each item when the fake clock,
+	// started at the first PubDate, reaches its PubDate
+	Clock bool
+	// Speed is how many times the fake clock runs faster than real time,
+	// 0 keeps PubDate order without waiting
+	Speed float64
+}
+
+// Source replays model.Item JSON lines from a dump, the input ends with the file
+type Source struct {
+	path        string
+	options     Options
+	textChannel chan model.News
+	logger      interfaces.Logger
+	read        int
+}
+
+var _ interfaces.Source = (*Source)(nil)
+
+// New replays path, "-" means stdin
+func New(path string, options Options, logger interfaces.Logger) *Source {
+	return &Source{
+		path:        path,
+		options:     options,
+		textChannel: make(chan model.News),
+		logger:      logger,
+	}
+}
+
+func (s *Source) TextOutput() <-chan model.News {
+	return s.textChannel
+}
+
+func (s *Source) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Read returns the number of items sent to processing
+func (s *Source) Read() int {
+	return s.read
+}
+
+func (s *Source) StartReadingText(ctx context.Context) {
+	defer close(s.textChannel)
+
+	reader := io.ReadCloser(os.Stdin)
+	if s.path != "-" {
+		file, err := os.Open(s.path)
+		if err != nil {
+			s.logger.Error("Error opening dump", "error", err, "path", s.path)
+			return
+		}
+		reader = file
+	}
+	defer reader.Close()
+
+	items := make(chan model.Item)
+	go func() {
+		defer close(items)
+		if s.options.Clock {
+			s.sorted(ctx, reader, items)
+		} else {
+			s.scan(reader, func(item model.Item) bool {
+				return send(ctx, items, item)
+			})
+		}
+	}()
+
+	var ticker *time.Ticker
+	if s.options.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / s.options.Rate))
+		defer ticker.Stop()
+	}
+	clock := newClock(s.options.Speed)
+	for item := range items {
+		if s.options.Clock && item.PubDate != nil && !clock.wait(ctx, *item.PubDate) {
+			return
+		}
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !send(ctx, s.textChannel, item.News()) {
+			return
+		}
+		s.read++
+	}
+}
+
+func send[T any](ctx context.Context, channel chan<- T, value T) bool {
+	select {
+	case channel <- value:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// scan decodes lines, skipping broken ones, until handle returns false
+func (s *Source) scan(reader io.Reader, handle func(model.Item) bool) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		metrics.TransportConsumed.WithLabelValues("replay").Inc()
+		item, err := schema.DecodeItem(scanner.Bytes(), nil, time.Now())
+		if err != nil {
+			metrics.MessagesRejected.WithLabelValues("replay").Inc()
+			s.logger.Warn("Rejecting line", "error", err, "line", line)
+			continue
+		}
+		if !handle(item) {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.logger.Error("Error reading dump", "error", err, "line", line)
+	}
+}
+
+// sorted reads the whole dump and sends items ordered by PubDate,
+// items without PubDate go first
+func (s *Source) sorted(ctx context.Context, reader io.Reader, items chan<- model.Item) {
+	all := []model.Item{}
+	s.scan(reader, func(item model.Item) bool {
+		all = append(all, item)
+		return true
+	})
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].PubDate == nil || all[j].PubDate == nil {
+			return all[i].PubDate == nil && all[j].PubDate != nil
+		}
+		return all[i].PubDate.Before(*all[j].PubDate)
+	})
+	s.logger.Info("Dump loaded", "items", len(all))
+	for _, item := range all {
+		if !send(ctx, items, item) {
+			return
+		}
+	}
+}
+
+// clock is a fake clock which starts at the first PubDate it waits for
+// and runs speed times faster than real time
+type clock struct {
+	speed   float64
+	started time.Time
+	origin  time.Time
+}
+
+func newClock(speed float64) *clock {
+	return &clock{speed: speed}
+}
+
+func (c *clock) now() time.Time {
+	elapsed := time.Since(c.started)
+	return c.origin.Add(time.Duration(float64(elapsed) * c.speed))
+}
+
+// wait blocks until fake time reaches date, false means that ctx is done
+func (c *clock) wait(ctx context.Context, date time.Time) bool {
+	if c.speed <= 0 {
+		return true
+	}
+	if c.origin.IsZero() {
+		c.origin = date
+		c.started = time.Now()
+		return true
+	}
+	ahead := date.Sub(c.now())
+	if ahead <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(float64(ahead) / c.speed))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
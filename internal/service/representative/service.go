@@ -0,0 +1,169 @@
+package representative
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/service/vector"
+)
+
+const (
+	// RuleCentroid picks the member closest to the cluster centroid
+	RuleCentroid = "centroid"
+	// RuleTrusted picks the member closest to the centroid among news of TRUSTED_SOURCES
+	RuleTrusted = "trusted"
+	// RuleLongest picks the member with the longest text
+	RuleLongest = "longest"
+	// RuleEarliest picks the first received member, the one with the smallest id
+	RuleEarliest = "earliest"
+)
+
+// Member is a news of the cluster
+type Member struct {
+	FeedID      int64
+	Title       string
+	Description string
+	FullText    string
+	Link        string
+	Embedding   *vector.Vector
+}
+
+// Choice is the representative article and the headline of a cluster
+type Choice struct {
+	FeedID   int64
+	Headline string
+}
+
+type Selector struct {
+	rule    string
+	trusted []string
+	logger  interfaces.Logger
+}
+
+// New reads REPRESENTATIVE_RULE (centroid, trusted, longest, earliest) and
+// TRUSTED_SOURCES, a comma separated list of hosts for the trusted rule
+func New(logger interfaces.Logger) (*Selector, error) {
+	s := &Selector{rule: os.Getenv("REPRESENTATIVE_RULE"), logger: logger}
+	switch s.rule {
+	case "":
+		s.rule = RuleCentroid
+	case RuleCentroid, RuleTrusted, RuleLongest, RuleEarliest:
+	default:
+		return nil, fmt.Errorf("unknown REPRESENTATIVE_RULE %q", s.rule)
+	}
+	for _, host := range strings.Split(os.Getenv("TRUSTED_SOURCES"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			s.trusted = append(s.trusted, host)
+		}
+	}
+	if s.rule == RuleTrusted && len(s.trusted) == 0 {
+		logger.Warn("TRUSTED_SOURCES is empty, representative is the member closest to the centroid")
+	}
+	return s, nil
+}
+
+// Select chooses the representative member and the headline. Members without
+// embedding are never closest to the centroid. Returns zero Choice for no members.
+func (s *Selector) Select(members []Member, centroid *vector.Vector) Choice {
+	if len(members) == 0 {
+		return Choice{}
+	}
+	best := 0
+	for i := 1; i < len(members); i++ {
+		if s.better(members[i], members[best], centroid) {
+			best = i
+		}
+	}
+	return Choice{FeedID: members[best].FeedID, Headline: Headline(members, best)}
+}
+
+// better reports whether a should represent the cluster instead of b
+func (s *Selector) better(a, b Member, centroid *vector.Vector) bool {
+	switch s.rule {
+	case RuleEarliest:
+		return a.FeedID < b.FeedID
+	case RuleLongest:
+		if la, lb := length(a), length(b); la != lb {
+			return la > lb
+		}
+	case RuleTrusted:
+		if ta, tb := s.isTrusted(a.Link), s.isTrusted(b.Link); ta != tb {
+			return ta
+		}
+	}
+	sa, sb := similarity(a, centroid), similarity(b, centroid)
+	if sa != sb {
+		return sa > sb
+	}
+	return a.FeedID < b.FeedID
+}
+
+func similarity(member Member, centroid *vector.Vector) float64 {
+	if member.Embedding == nil || centroid == nil {
+		return -2
+	}
+	return member.Embedding.CosDistance(centroid)
+}
+
+func length(member Member) int {
+	if len(member.FullText) > 0 {
+		return len([]rune(member.FullText))
+	}
+	return len([]rune(member.Description))
+}
+
+// isTrusted matches the host of the link and its parent domains
+func (s *Selector) isTrusted(link string) bool {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	for _, trusted := range s.trusted {
+		if host == trusted || strings.HasSuffix(host, "."+trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// Headline returns the member title sharing most words with other titles,
+// which is the most typical wording of the story. The title of the member
+// at representative wins ties and is used for clusters of one or two news.
+func Headline(members []Member, representative int) string {
+	titles := make([][]string, len(members))
+	count := 0
+	for i, member := range members {
+		titles[i] = vector.Tokenize(member.Title)
+		if len(titles[i]) > 0 {
+			count++
+		}
+	}
+	if count < 3 {
+		return strings.TrimSpace(members[representative].Title)
+	}
+	vectors := vector.TFIDF(titles)
+	best, bestScore := representative, centrality(vectors, representative)
+	for i := range members {
+		if len(titles[i]) == 0 || i == representative {
+			continue
+		}
+		if score := centrality(vectors, i); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return strings.TrimSpace(members[best].Title)
+}
+
+func centrality(vectors []*vector.Vector, i int) float64 {
+	sum := 0.0
+	for j, other := range vectors {
+		if j != i {
+			sum += vectors[i].CosDistance(other)
+		}
+	}
+	return sum
+}
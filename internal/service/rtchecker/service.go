@@ -4,6 +4,7 @@ import (
 	"agregator/group/internal/interfaces"
 	model "agregator/group/internal/model/kafka"
 	"agregator/group/internal/service/db"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -11,28 +12,22 @@ import (
 
 type Service struct {
 	words  []string
-	db     *db.DB
+	db     db.RTWords
 	logger interfaces.Logger
 	mu     sync.Mutex
 }
 
-func New(logger interfaces.Logger) *Service {
-	db, err := db.New(1)
-	if err != nil {
-		logger.Error("Error creating db", "error", err)
-		return nil
-	}
+func New(db db.RTWords, logger interfaces.Logger) (*Service, error) {
 	words, err := db.GetRTWords()
 	if err != nil {
-		logger.Error("Error getting RT words", "error", err)
-		return nil
+		return nil, fmt.Errorf("getting RT words: %w", err)
 	}
 	return &Service{
 		words:  words,
 		mu:     sync.Mutex{},
 		db:     db,
 		logger: logger,
-	}
+	}, nil
 }
 
 func (s *Service) CheckForRT(item *model.News) bool {
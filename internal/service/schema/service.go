@@ -0,0 +1,267 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const (
+	// VersionHeader carries schema version of the message payload
+	VersionHeader = "schema-version"
+
+	// ItemVersion is the current version of input items. Items without
+	// the version header are decoded as version 1.
+	ItemVersion    = 2
+	NewsVersion    = 1
+	EditVersion    = 1
+	ClusterVersion = 1
+)
+
+// ErrInvalid wraps validation and decoding errors of messages
+var ErrInvalid = errors.New("invalid message")
+
+//go:embed schemas/*.json
+var files embed.FS
+
+var (
+	items  = map[int]*jsonschema.Schema{}
+	events = map[string]eventSchema{}
+	// clusterEvents are events of the cluster events topic
+	clusterEvents = map[string]eventSchema{}
+)
+
+type eventSchema struct {
+	schema  *jsonschema.Schema
+	version int
+}
+
+func init() {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat()
+	entries, err := files.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		data, err := files.ReadFile("schemas/" + entry.Name())
+		if err != nil {
+			panic(err)
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			panic(fmt.Errorf("schema %s: %w", entry.Name(), err))
+		}
+		if err := compiler.AddResource(entry.Name(), doc); err != nil {
+			panic(err)
+		}
+	}
+	compile := func(name string) *jsonschema.Schema {
+		schema, err := compiler.Compile(name)
+		if err != nil {
+			panic(fmt.Errorf("schema %s: %w", name, err))
+		}
+		return schema
+	}
+
+	items[1] = compile("item.v1.json")
+	items[2] = compile("item.v2.json")
+	news := eventSchema{compile("news.v1.json"), NewsVersion}
+	events[model.EventArticleCreated] = news
+	events[model.EventArticleUpdated] = news
+	cluster := eventSchema{compile("cluster.v1.json"), ClusterVersion}
+	for _, event := range []string{model.EventClusterCreated, model.EventClusterUpdated, model.EventClusterClosed, model.EventClusterMerged} {
+		clusterEvents[event] = cluster
+	}
+	// Правки редактора пишутся в топик событий кластеров
+	edit := eventSchema{compile("edit.v1.json"), EditVersion}
+	for _, event := range []string{model.EventArticleMoved, model.EventArticleDetached, model.EventArticlePinned, model.EventArticleMerged} {
+		clusterEvents[event] = edit
+	}
+}
+
+func validate(schema *jsonschema.Schema, data []byte) error {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := schema.Validate(doc); err != nil {
+		var validationErr *jsonschema.ValidationError
+		if errors.As(err, &validationErr) {
+			return fmt.Errorf("%w: %s", ErrInvalid, flatten(validationErr))
+		}
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// flatten returns the first leaf error, which names the failing field
+func flatten(err *jsonschema.ValidationError) string {
+	for len(err.Causes) > 0 {
+		err = err.Causes[0]
+	}
+	return err.Error()
+}
+
+// DecodeItem validates and decodes an input item according to the version header.
+// Version 1 items may have string ids and missing or Unix millis pubDate,
+// received is used when pubDate is missing.
+func DecodeItem(data []byte, headers map[string]string, received time.Time) (model.Item, error) {
+	version := 1
+	if value := headers[VersionHeader]; value != "" {
+		var err error
+		version, err = strconv.Atoi(value)
+		if err != nil {
+			return model.Item{}, fmt.Errorf("%w: bad %s %q", ErrInvalid, VersionHeader, value)
+		}
+	}
+	schema, ok := items[version]
+	if !ok {
+		return model.Item{}, fmt.Errorf("%w: unsupported item version %d", ErrInvalid, version)
+	}
+	if err := validate(schema, data); err != nil {
+		return model.Item{}, err
+	}
+
+	if version > 1 {
+		item := model.Item{}
+		if err := json.Unmarshal(data, &item); err != nil {
+			return model.Item{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return item, nil
+	}
+	return decodeLegacy(data, received)
+}
+
+func decodeLegacy(data []byte, received time.Time) (model.Item, error) {
+	var legacy struct {
+		model.Item
+		ID      json.Number     `json:"id"`
+		PubDate json.RawMessage `json:"pubDate"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&legacy); err != nil {
+		return model.Item{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	item := legacy.Item
+	id, err := strconv.Atoi(legacy.ID.String())
+	if err != nil {
+		return model.Item{}, fmt.Errorf("%w: id: %v", ErrInvalid, err)
+	}
+	item.ID = id
+
+	date := received.UTC()
+	var value any
+	if len(legacy.PubDate) > 0 {
+		if err := json.Unmarshal(legacy.PubDate, &value); err != nil {
+			return model.Item{}, fmt.Errorf("%w: pubDate: %v", ErrInvalid, err)
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		date, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return model.Item{}, fmt.Errorf("%w: pubDate: %v", ErrInvalid, err)
+		}
+	case float64:
+		date = time.UnixMilli(int64(value)).UTC()
+	}
+	item.PubDate = &date
+	return item, nil
+}
+
+// ValidateEvent checks an output payload against the schema of the event
+// and returns its version, 0 for events without schema
+func ValidateEvent(event string, payload []byte) (int, error) {
+	return validateEvent(events, event, payload)
+}
+
+// ValidateClusterEvent is ValidateEvent for the cluster events topic
+func ValidateClusterEvent(event string, payload []byte) (int, error) {
+	return validateEvent(clusterEvents, event, payload)
+}
+
+func validateEvent(events map[string]eventSchema, event string, payload []byte) (int, error) {
+	schema, ok := events[event]
+	if !ok {
+		return 0, nil
+	}
+	if err := validate(schema.schema, payload); err != nil {
+		return 0, fmt.Errorf("event %s: %w", event, err)
+	}
+	return schema.version, nil
+}
+
+// Sink validates messages before writing them and adds the version header
+type Sink struct {
+	sink   interfaces.BatchSink
+	events map[string]eventSchema
+	logger interfaces.Logger
+}
+
+var _ interfaces.BatchSink = (*Sink)(nil)
+
+func NewSink(sink interfaces.BatchSink, logger interfaces.Logger) *Sink {
+	return &Sink{sink: sink, events: events, logger: logger}
+}
+
+// NewClusterSink validates messages of the cluster events topic
+func NewClusterSink(sink interfaces.BatchSink, logger interfaces.Logger) *Sink {
+	return &Sink{sink: sink, events: clusterEvents, logger: logger}
+}
+
+func (s *Sink) Ping(ctx context.Context) error {
+	return s.sink.Ping(ctx)
+}
+
+func (s *Sink) WriteMessage(ctx context.Context, event string, key, message []byte, headers map[string]string) error {
+	headers, err := s.prepare(event, key, message, headers)
+	if err != nil {
+		return err
+	}
+	return s.sink.WriteMessage(ctx, event, key, message, headers)
+}
+
+// WriteMessages validates all messages and writes them in one batch, nothing is
+// written if one of them is invalid
+func (s *Sink) WriteMessages(ctx context.Context, messages []interfaces.Message) error {
+	prepared := make([]interfaces.Message, len(messages))
+	for i, message := range messages {
+		headers, err := s.prepare(message.Event, message.Key, message.Value, message.Headers)
+		if err != nil {
+			return err
+		}
+		message.Headers = headers
+		prepared[i] = message
+	}
+	return s.sink.WriteMessages(ctx, prepared)
+}
+
+// prepare validates the message and returns headers with the schema version
+func (s *Sink) prepare(event string, key, message []byte, headers map[string]string) (map[string]string, error) {
+	version, err := validateEvent(s.events, event, message)
+	if err != nil {
+		s.logger.Error("Refusing to write invalid event", "error", err, "event", event, "key", string(key))
+		return nil, err
+	}
+	if version == 0 {
+		return headers, nil
+	}
+	extended := make(map[string]string, len(headers)+1)
+	for name, value := range headers {
+		extended[name] = value
+	}
+	extended[VersionHeader] = strconv.Itoa(version)
+	return extended, nil
+}
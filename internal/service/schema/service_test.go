@@ -0,0 +1,87 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	model "agregator/group/internal/model/kafka"
+)
+
+func TestDecodeItem(t *testing.T) {
+	received := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	published := time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)
+	current := map[string]string{VersionHeader: "2"}
+
+	tests := []struct {
+		name    string
+		data    string
+		headers map[string]string
+		id      int
+		date    time.Time
+		invalid bool
+	}{
+		{name: "current", data: `{"id":7,"title":"a","pubDate":"2024-04-30T08:00:00Z"}`, headers: current, id: 7, date: published},
+		{name: "current without pubDate", data: `{"id":7,"title":"a"}`, headers: current, invalid: true},
+		{name: "current with bad date", data: `{"id":7,"title":"a","pubDate":"yesterday"}`, headers: current, invalid: true},
+		{name: "current with string id", data: `{"id":"7","title":"a","pubDate":"2024-04-30T08:00:00Z"}`, headers: current, invalid: true},
+		{name: "legacy", data: `{"id":7,"title":"a","pubDate":"2024-04-30T08:00:00Z"}`, id: 7, date: published},
+		{name: "legacy null pubDate", data: `{"id":7,"title":"a","pubDate":null}`, id: 7, date: received},
+		{name: "legacy without pubDate", data: `{"id":7}`, id: 7, date: received},
+		{name: "legacy millis and string id", data: `{"id":"7","pubDate":1714464000000}`, id: 7, date: published},
+		{name: "legacy without id", data: `{"title":"a"}`, invalid: true},
+		{name: "not json", data: `{"id":`, invalid: true},
+		{name: "unknown version", data: `{"id":7}`, headers: map[string]string{VersionHeader: "9"}, invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item, err := DecodeItem([]byte(test.data), test.headers, received)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalid) {
+					t.Fatalf("err = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.ID != test.id {
+				t.Errorf("id = %d, want %d", item.ID, test.id)
+			}
+			if item.PubDate == nil || !item.PubDate.Equal(test.date) {
+				t.Errorf("pubDate = %v, want %v", item.PubDate, test.date)
+			}
+		})
+	}
+}
+
+func TestValidateEvent(t *testing.T) {
+	version, err := ValidateEvent(model.EventArticleCreated, []byte(`{"id":1,"cluster_id":2,"title":"a","full_text":"","embedding":[0.1],"publish_date":"2024-04-30T08:00:00Z","is_rt":false,"is_duplicate":false}`))
+	if err != nil || version != NewsVersion {
+		t.Errorf("valid news: version = %d, err = %v", version, err)
+	}
+	_, err = ValidateEvent(model.EventArticleCreated, []byte(`{"id":1,"cluster_id":0,"title":"a"}`))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("news without cluster: err = %v, want ErrInvalid", err)
+	}
+	version, err = ValidateEvent("unknown.event", []byte(`not json`))
+	if err != nil || version != 0 {
+		t.Errorf("unknown event: version = %d, err = %v", version, err)
+	}
+}
+
+func TestValidateClusterEvent(t *testing.T) {
+	version, err := ValidateClusterEvent(model.EventClusterMerged, []byte(`{"event":"cluster.merged","cluster_id":2,"merged_from":3,"size":4,"is_rt":false,"time":"2024-04-30T08:00:00Z"}`))
+	if err != nil || version != ClusterVersion {
+		t.Errorf("valid merge: version = %d, err = %v", version, err)
+	}
+	_, err = ValidateClusterEvent(model.EventClusterMerged, []byte(`{"event":"cluster.merged","cluster_id":2,"actor":"editor","time":"2024-04-30T08:00:00Z"}`))
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("merge without size: err = %v, want ErrInvalid", err)
+	}
+	// Слияние редактором описывается своим событием, а не cluster.merged
+	version, err = ValidateClusterEvent(model.EventArticleMerged, []byte(`{"event":"article.merged","cluster_id":2,"previous_cluster_id":3,"moved":[5],"actor":"editor","time":"2024-04-30T08:00:00Z"}`))
+	if err != nil || version != EditVersion {
+		t.Errorf("edit merge: version = %d, err = %v", version, err)
+	}
+}
@@ -0,0 +1,271 @@
+package summary
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"agregator/group/internal/interfaces"
+	"agregator/group/service/vector"
+)
+
+const (
+	// minTokens drops headers, bylines and other fragments which are not sentences
+	minTokens = 4
+	// edgeThreshold is the minimal similarity of sentences linked in the LexRank graph
+	edgeThreshold = 0.1
+	// redundancy is the similarity above which a sentence repeats an already chosen one
+	redundancy = 0.6
+	// textSentences is how many leading sentences of each text are ranked, the lead of
+	// a news carries its point and the cap keeps the LexRank graph small
+	textSentences = 10
+	damping       = 0.85
+	iterations    = 50
+	convergence   = 1e-6
+)
+
+// Summarizer builds extractive cluster summaries with LexRank over TF-IDF vectors
+// of member sentences and debounces recalculation of changing clusters
+type Summarizer struct {
+	sentences int
+	debounce  time.Duration
+	maxDelay  time.Duration
+	logger    interfaces.Logger
+
+	mu      sync.Mutex
+	pending map[int64]*schedule
+}
+
+type schedule struct {
+	first time.Time
+	due   time.Time
+}
+
+// New reads SUMMARY_SENTENCES, the summary length (3 by default), and SUMMARY_DEBOUNCE,
+// the quiet period after the last change of a cluster before its summary is rebuilt
+// (30s by default). A growing cluster is summarized at least every 5 debounce periods.
+func New(logger interfaces.Logger) (*Summarizer, error) {
+	s := &Summarizer{
+		sentences: 3,
+		debounce:  30 * time.Second,
+		logger:    logger,
+		pending:   map[int64]*schedule{},
+	}
+	if value := os.Getenv("SUMMARY_SENTENCES"); value != "" {
+		sentences, err := strconv.Atoi(value)
+		if err != nil || sentences <= 0 {
+			return nil, fmt.Errorf("invalid SUMMARY_SENTENCES %q", value)
+		}
+		s.sentences = sentences
+	}
+	if value := os.Getenv("SUMMARY_DEBOUNCE"); value != "" {
+		debounce, err := time.ParseDuration(value)
+		if err != nil || debounce < 0 {
+			return nil, fmt.Errorf("invalid SUMMARY_DEBOUNCE %q", value)
+		}
+		s.debounce = debounce
+	}
+	s.maxDelay = 5 * s.debounce
+	return s, nil
+}
+
+// Schedule requests summary of the cluster after the debounce period
+func (s *Summarizer) Schedule(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	entry, ok := s.pending[id]
+	if !ok {
+		entry = &schedule{first: now}
+		s.pending[id] = entry
+	}
+	entry.due = now.Add(s.debounce)
+	if limit := entry.first.Add(s.maxDelay); entry.due.After(limit) {
+		entry.due = limit
+	}
+}
+
+// Due removes and returns clusters which summaries are due at now
+func (s *Summarizer) Due(now time.Time) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []int64{}
+	for id, entry := range s.pending {
+		if !entry.due.After(now) {
+			ids = append(ids, id)
+			delete(s.pending, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Interval is how often Due should be checked
+func (s *Summarizer) Interval() time.Duration {
+	return max(s.debounce/4, 100*time.Millisecond)
+}
+
+type sentence struct {
+	text   string
+	tokens []string
+}
+
+// Summarize returns the most central sentences of the texts in their original order.
+// Texts are expected in a stable order, e.g. by publication, only the first
+// sentences of each text are considered.
+func (s *Summarizer) Summarize(texts []string) string {
+	all := []sentence{}
+	seen := map[string]bool{}
+	for _, text := range texts {
+		parts := Sentences(text)
+		if len(parts) > textSentences {
+			parts = parts[:textSentences]
+		}
+		for _, part := range parts {
+			tokens := vector.Tokenize(part)
+			key := strings.Join(tokens, " ")
+			if len(tokens) < minTokens || seen[key] {
+				continue
+			}
+			seen[key] = true
+			all = append(all, sentence{text: part, tokens: tokens})
+		}
+	}
+	if len(all) == 0 {
+		return ""
+	}
+
+	documents := make([][]string, len(all))
+	for i, item := range all {
+		documents[i] = item.tokens
+	}
+	vectors := vector.TFIDF(documents)
+	scores := lexRank(vectors)
+
+	ranked := make([]int, len(all))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+
+	chosen := []int{}
+	for _, i := range ranked {
+		if len(chosen) == s.sentences {
+			break
+		}
+		repeated := false
+		for _, j := range chosen {
+			if vectors[i].CosDistance(vectors[j]) > redundancy {
+				repeated = true
+				break
+			}
+		}
+		if !repeated {
+			chosen = append(chosen, i)
+		}
+	}
+	sort.Ints(chosen)
+	parts := make([]string, len(chosen))
+	for i, index := range chosen {
+		parts[i] = all[index].text
+	}
+	return strings.Join(parts, " ")
+}
+
+// lexRank runs PageRank over the graph of sentences linked by cosine similarity
+func lexRank(vectors []*vector.Vector) []float64 {
+	n := len(vectors)
+	weights := make([][]float64, n)
+	degree := make([]float64, n)
+	for i := range vectors {
+		weights[i] = make([]float64, n)
+		for j := range vectors {
+			if i == j {
+				continue
+			}
+			if similarity := vectors[i].CosDistance(vectors[j]); similarity >= edgeThreshold {
+				weights[i][j] = similarity
+				degree[i] += similarity
+			}
+		}
+	}
+
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1 / float64(n)
+	}
+	for iteration := 0; iteration < iterations; iteration++ {
+		next := make([]float64, n)
+		delta := 0.0
+		for i := range next {
+			sum := 0.0
+			for j := range vectors {
+				if weights[j][i] > 0 {
+					sum += weights[j][i] / degree[j] * scores[j]
+				}
+			}
+			next[i] = (1-damping)/float64(n) + damping*sum
+			delta += math.Abs(next[i] - scores[i])
+		}
+		scores = next
+		if delta < convergence {
+			break
+		}
+	}
+	return scores
+}
+
+// abbreviations do not end a sentence when followed by a space
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "st": true, "vs": true,
+	"e.g": true, "i.e": true, "etc": true, "inc": true, "ltd": true, "co": true, "jr": true,
+	"т.е": true, "т.д": true, "т.п": true, "т.к": true, "др": true, "пр": true,
+	"г": true, "гг": true, "им": true, "ул": true, "млн": true, "млрд": true, "тыс": true, "руб": true,
+}
+
+// Sentences splits text by sentence ending punctuation followed by a space
+// and by line breaks. A period after an abbreviation or an initial does not end a sentence.
+func Sentences(text string) []string {
+	result := []string{}
+	runes := []rune(text)
+	start := 0
+	flush := func(end int) {
+		if part := strings.TrimSpace(string(runes[start:end])); part != "" {
+			result = append(result, part)
+		}
+		start = end
+	}
+	for i, r := range runes {
+		switch {
+		case r == '\n':
+			flush(i + 1)
+		case strings.ContainsRune(".!?…", r) && i+1 < len(runes) && unicode.IsSpace(runes[i+1]):
+			if r == '.' && abbreviated(runes[start:i]) {
+				continue
+			}
+			flush(i + 1)
+		}
+	}
+	flush(len(runes))
+	return result
+}
+
+// abbreviated reports whether the text ends with an abbreviation or a single letter initial
+func abbreviated(runes []rune) bool {
+	begin := len(runes)
+	for begin > 0 && !unicode.IsSpace(runes[begin-1]) {
+		begin--
+	}
+	word := strings.ToLower(strings.TrimLeft(string(runes[begin:]), "(\"«"))
+	if utf8.RuneCountInString(word) == 1 {
+		return unicode.IsLetter([]rune(word)[0])
+	}
+	return abbreviations[word]
+}
@@ -0,0 +1,86 @@
+package summary
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "empty", text: "", want: []string{}},
+		{name: "spaces", text: " \n ", want: []string{}},
+		{name: "punctuation", text: "Rates rose. Did they? Yes! Done", want: []string{"Rates rose.", "Did they?", "Yes!", "Done"}},
+		{name: "ellipsis", text: "Markets waited… Then rates rose.", want: []string{"Markets waited…", "Then rates rose."}},
+		{name: "newlines", text: "Headline\nFirst line of the text\n\nSecond paragraph", want: []string{"Headline", "First line of the text", "Second paragraph"}},
+		{name: "no space after period", text: "Version 2.5 is out.Next", want: []string{"Version 2.5 is out.Next"}},
+		{name: "abbreviations", text: "Mr. Smith met Dr. Brown, e.g. at lunch. They talked.", want: []string{"Mr. Smith met Dr. Brown, e.g. at lunch.", "They talked."}},
+		{name: "initials", text: "J. R. Smith spoke. Prices fell.", want: []string{"J. R. Smith spoke.", "Prices fell."}},
+		{name: "russian abbreviations", text: "Курс вырос на 2 руб. и т.д. по плану. Рынок ждал.", want: []string{"Курс вырос на 2 руб. и т.д. по плану.", "Рынок ждал."}},
+		{name: "number at the end", text: "The rate is 16. It was 15.", want: []string{"The rate is 16.", "It was 15."}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sentences(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Sentences(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name      string
+		sentences int
+		texts     []string
+		want      string
+	}{
+		{name: "no texts", sentences: 2, texts: nil, want: ""},
+		{name: "empty texts", sentences: 2, texts: []string{"", " "}, want: ""},
+		{name: "only short fragments", sentences: 2, texts: []string{"Breaking news.", "By staff"}, want: ""},
+		{
+			name:      "redundant sentence is skipped",
+			sentences: 2,
+			texts: []string{
+				"The central bank raised the key rate to sixteen percent.",
+				"The central bank raised the key rate to sixteen percent on Friday.",
+				"Mortgage lenders will raise their rates after the central bank decision.",
+			},
+			want: "The central bank raised the key rate to sixteen percent. Mortgage lenders will raise their rates after the central bank decision.",
+		},
+		{
+			name:      "exact duplicates are counted once",
+			sentences: 3,
+			texts: []string{
+				"The central bank raised the key rate.",
+				"The central bank raised the key rate.",
+			},
+			want: "The central bank raised the key rate.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Summarizer{sentences: test.sentences}
+			if got := s.Summarize(test.texts); got != test.want {
+				t.Errorf("Summarize() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeLeadSentences(t *testing.T) {
+	// Из длинного текста ранжируются только первые предложения
+	parts := make([]string, 0, textSentences+1)
+	for i := 0; i < textSentences; i++ {
+		parts = append(parts, "Filler sentence number "+strings.Repeat("x", i+1)+" about weather.")
+	}
+	parts = append(parts, "The central bank raised the key rate today.")
+	s := &Summarizer{sentences: textSentences + 1}
+	if got := s.Summarize([]string{strings.Join(parts, " ")}); strings.Contains(got, "central bank") {
+		t.Errorf("summary %q contains a sentence after the first %d", got, textSentences)
+	}
+}
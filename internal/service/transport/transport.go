@@ -0,0 +1,162 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"agregator/group/internal/interfaces"
+	model "agregator/group/internal/model/kafka"
+	"agregator/group/internal/service/codec"
+	"agregator/group/internal/service/jsonl"
+	"agregator/group/internal/service/kafka"
+	"agregator/group/internal/service/nats"
+	"agregator/group/internal/service/redis"
+)
+
+// New creates source and sink selected by SOURCE (kafka, nats, redis, file, stdin)
+// and SINK (kafka, nats, redis, stdout, file). Both default to kafka.
+// OUTPUT_FORMAT (json, protobuf) selects encoding of news on the output topic only.
+// The third result writes cluster lifecycle events to the cluster events topic
+// of the sink transport, they are always JSON. It is nil when CLUSTER_EVENTS is false.
+func New(logger interfaces.Logger) (interfaces.Source, interfaces.Sink, interfaces.Sink, error) {
+	var (
+		kafkaBus *kafka.Kafka
+		natsBus  *nats.Nats
+		redisBus *redis.Redis
+		err      error
+	)
+	getKafka := func() (*kafka.Kafka, error) {
+		if kafkaBus == nil {
+			config, err := kafka.ConfigFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			kafkaBus, err = kafka.New(config, logger)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return kafkaBus, nil
+	}
+	getNats := func() (*nats.Nats, error) {
+		if natsBus == nil {
+			natsBus, err = nats.New(logger)
+		}
+		return natsBus, err
+	}
+	getRedis := func() (*redis.Redis, error) {
+		if redisBus == nil {
+			redisBus = redis.New(logger)
+		}
+		return redisBus, nil
+	}
+
+	var source interfaces.Source
+	switch name := os.Getenv("SOURCE"); name {
+	case "", "kafka":
+		source, err = getKafka()
+	case "nats":
+		source, err = getNats()
+	case "redis":
+		source, err = getRedis()
+	case "file", "stdin":
+		source, err = jsonl.NewSource(os.Getenv("SOURCE_FILE"), logger)
+	default:
+		err = fmt.Errorf("unknown SOURCE %q", name)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("creating source: %w", err)
+	}
+
+	var sink, clusters interfaces.Sink
+	switch name := os.Getenv("SINK"); name {
+	case "", "kafka":
+		var bus *kafka.Kafka
+		if bus, err = getKafka(); err == nil {
+			sink, clusters = bus, bus.Clusters()
+		}
+	case "nats":
+		var bus *nats.Nats
+		if bus, err = getNats(); err == nil {
+			sink, clusters = bus, bus.Clusters()
+		}
+	case "redis":
+		var bus *redis.Redis
+		if bus, err = getRedis(); err == nil {
+			sink, clusters = bus, bus.Clusters()
+		}
+	case "file", "stdout":
+		// В файл события кластеров пишутся вместе с новостями, их различает поле event
+		var file *jsonl.Sink
+		if file, err = jsonl.NewSink(os.Getenv("SINK_FILE"), true, logger); err == nil {
+			sink, clusters = file, file
+		}
+	default:
+		err = fmt.Errorf("unknown SINK %q", name)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("creating sink: %w", err)
+	}
+	encoded, err := codec.NewSink(Batch(sink), os.Getenv("OUTPUT_FORMAT"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if os.Getenv("CLUSTER_EVENTS") == "false" {
+		return source, encoded, nil, nil
+	}
+	// Для событий кластеров нет protobuf схемы, OUTPUT_FORMAT к ним не применяется
+	clustersEncoded, err := codec.NewSink(Batch(clusters), codec.FormatJSON)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return source, encoded, clustersEncoded, nil
+}
+
+// WriteEvent writes news with event type in the "event" header
+func WriteEvent(ctx context.Context, sink interfaces.Sink, event string, news model.News) error {
+	message, err := json.Marshal(news)
+	if err != nil {
+		return err
+	}
+	return sink.WriteMessage(ctx, event, []byte(strconv.FormatInt(news.ID, 10)), message, nil)
+}
+
+// WriteJSON writes any event payload as JSON
+func WriteJSON(ctx context.Context, sink interfaces.Sink, event string, key string, payload any) error {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return sink.WriteMessage(ctx, event, []byte(key), message, nil)
+}
+
+// WriteMessages writes messages in one request when the sink supports batches
+// and one by one otherwise, stopping at the first error
+func WriteMessages(ctx context.Context, sink interfaces.Sink, messages []interfaces.Message) error {
+	return Batch(sink).WriteMessages(ctx, messages)
+}
+
+// Batch returns the sink itself when it supports batches, otherwise a sink
+// writing batches one message at a time
+func Batch(sink interfaces.Sink) interfaces.BatchSink {
+	if batch, ok := sink.(interfaces.BatchSink); ok {
+		return batch
+	}
+	return batchSink{sink}
+}
+
+type batchSink struct {
+	interfaces.Sink
+}
+
+func (s batchSink) WriteMessages(ctx context.Context, messages []interfaces.Message) error {
+	for _, message := range messages {
+		if err := s.WriteMessage(ctx, message.Event, message.Key, message.Value, message.Headers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+// Package newspb encodes and decodes clustered news written by the group maker.
+// The wire format is described in news.proto, embeddings are packed float32.
+package newspb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	ContentTypeHeader   = "content-type"
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+type Candidate struct {
+	ClusterID           int64   `json:"cluster_id"`
+	NewsCount           int64   `json:"news_count"`
+	Distance            float64 `json:"distance"`
+	Score               float64 `json:"score"`
+	Threshold           float64 `json:"threshold"`
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	Vetoed              bool    `json:"vetoed,omitempty"`
+	Matched             bool    `json:"matched"`
+}
+
+type Decision struct {
+	Outcome       string     `json:"outcome"`
+	Strategy      string     `json:"strategy"`
+	ConfigVersion string     `json:"config_version"`
+	Distance      float64    `json:"distance"`
+	Similarity    float64    `json:"similarity"`
+	Threshold     float64    `json:"threshold"`
+	ClusterSize   int64      `json:"cluster_size"`
+	RunnerUp      *Candidate `json:"runner_up,omitempty"`
+}
+
+// News has the same JSON form as the group maker JSON output
+type News struct {
+	ID          int64     `json:"id"`
+	ClusterID   int64     `json:"cluster_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	FullText    string    `json:"full_text"`
+	Enclosure   string    `json:"enclosure,omitempty"`
+	Embedding   []float32 `json:"embedding"`
+	PublishDate string    `json:"publish_date"`
+	IsRT        bool      `json:"is_rt"`
+	SourceName  string    `json:"source_name"`
+	URL         string    `json:"url"`
+	IsDuplicate bool      `json:"is_duplicate"`
+	DuplicateOf int64     `json:"duplicate_of,omitempty"`
+	Decision    *Decision `json:"decision,omitempty"`
+}
+
+var ErrMalformed = errors.New("malformed protobuf message")
+
+// Decode decodes a message according to its content type, empty means JSON
+func Decode(contentType string, data []byte) (News, error) {
+	switch contentType {
+	case ContentTypeProtobuf:
+		return Unmarshal(data)
+	case "", ContentTypeJSON:
+		var news News
+		err := json.Unmarshal(data, &news)
+		return news, err
+	}
+	return News{}, fmt.Errorf("unsupported content type %q", contentType)
+}
+
+func Marshal(news News) []byte {
+	b := make([]byte, 0, 64+len(news.Title)+len(news.Description)+len(news.FullText)+4*len(news.Embedding))
+	b = appendInt(b, 1, news.ID)
+	b = appendInt(b, 2, news.ClusterID)
+	b = appendString(b, 3, news.Title)
+	b = appendString(b, 4, news.Description)
+	b = appendString(b, 5, news.FullText)
+	b = appendString(b, 6, news.Enclosure)
+	if len(news.Embedding) > 0 {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(4*len(news.Embedding)))
+		for _, value := range news.Embedding {
+			b = protowire.AppendFixed32(b, math.Float32bits(value))
+		}
+	}
+	b = appendString(b, 8, news.PublishDate)
+	b = appendBool(b, 9, news.IsRT)
+	b = appendString(b, 10, news.SourceName)
+	b = appendString(b, 11, news.URL)
+	b = appendBool(b, 12, news.IsDuplicate)
+	b = appendInt(b, 13, news.DuplicateOf)
+	if news.Decision != nil {
+		b = appendMessage(b, 14, marshalDecision(news.Decision))
+	}
+	return b
+}
+
+func marshalDecision(decision *Decision) []byte {
+	var b []byte
+	b = appendString(b, 1, decision.Outcome)
+	b = appendString(b, 2, decision.Strategy)
+	b = appendString(b, 3, decision.ConfigVersion)
+	b = appendDouble(b, 4, decision.Distance)
+	b = appendDouble(b, 5, decision.Similarity)
+	b = appendDouble(b, 6, decision.Threshold)
+	b = appendInt(b, 7, decision.ClusterSize)
+	if decision.RunnerUp != nil {
+		b = appendMessage(b, 8, marshalCandidate(decision.RunnerUp))
+	}
+	return b
+}
+
+func marshalCandidate(candidate *Candidate) []byte {
+	var b []byte
+	b = appendInt(b, 1, candidate.ClusterID)
+	b = appendInt(b, 2, candidate.NewsCount)
+	b = appendDouble(b, 3, candidate.Distance)
+	b = appendDouble(b, 4, candidate.Score)
+	b = appendDouble(b, 5, candidate.Threshold)
+	b = appendDouble(b, 6, candidate.SimilarityThreshold)
+	b = appendBool(b, 7, candidate.Vetoed)
+	b = appendBool(b, 8, candidate.Matched)
+	return b
+}
+
+func appendInt(b []byte, num protowire.Number, value int64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(value))
+}
+
+func appendBool(b []byte, num protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendDouble(b []byte, num protowire.Number, value float64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(value))
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// field is a decoded field value, only one of the values is set by its wire type
+type field struct {
+	num     protowire.Number
+	typ     protowire.Type
+	varint  uint64
+	fixed32 uint32
+	fixed64 uint64
+	bytes   []byte
+}
+
+// wireTypes maps known field numbers of a message to their wire types
+type wireTypes map[protowire.Number]protowire.Type
+
+var (
+	// Поле 7 (embedding) проверяется отдельно: repeated float бывает упакованным и нет
+	newsTypes = wireTypes{
+		1: protowire.VarintType, 2: protowire.VarintType, 3: protowire.BytesType, 4: protowire.BytesType,
+		5: protowire.BytesType, 6: protowire.BytesType, 8: protowire.BytesType, 9: protowire.VarintType,
+		10: protowire.BytesType, 11: protowire.BytesType, 12: protowire.VarintType, 13: protowire.VarintType,
+		14: protowire.BytesType,
+	}
+	decisionTypes = wireTypes{
+		1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.BytesType, 4: protowire.Fixed64Type,
+		5: protowire.Fixed64Type, 6: protowire.Fixed64Type, 7: protowire.VarintType, 8: protowire.BytesType,
+	}
+	candidateTypes = wireTypes{
+		1: protowire.VarintType, 2: protowire.VarintType, 3: protowire.Fixed64Type, 4: protowire.Fixed64Type,
+		5: protowire.Fixed64Type, 6: protowire.Fixed64Type, 7: protowire.VarintType, 8: protowire.VarintType,
+	}
+)
+
+// fields walks over message fields. Unknown wire types and known fields with
+// another wire type end with ErrMalformed, unknown fields are skipped.
+func fields(data []byte, types wireTypes, handle func(field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %v", ErrMalformed, protowire.ParseError(n))
+		}
+		data = data[n:]
+		if want, ok := types[num]; ok && typ != want {
+			return fmt.Errorf("%w: field %d has wire type %d, want %d", ErrMalformed, num, typ, want)
+		}
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed32Type:
+			f.fixed32, n = protowire.ConsumeFixed32(data)
+		case protowire.Fixed64Type:
+			f.fixed64, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: field %d: %v", ErrMalformed, num, protowire.ParseError(n))
+		}
+		data = data[n:]
+		if err := handle(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Unmarshal(data []byte) (News, error) {
+	var news News
+	err := fields(data, newsTypes, func(f field) error {
+		switch f.num {
+		case 1:
+			news.ID = int64(f.varint)
+		case 2:
+			news.ClusterID = int64(f.varint)
+		case 3:
+			news.Title = string(f.bytes)
+		case 4:
+			news.Description = string(f.bytes)
+		case 5:
+			news.FullText = string(f.bytes)
+		case 6:
+			news.Enclosure = string(f.bytes)
+		case 7:
+			// Принимаем и упакованную, и неупакованную форму repeated float
+			switch f.typ {
+			case protowire.Fixed32Type:
+				news.Embedding = append(news.Embedding, math.Float32frombits(f.fixed32))
+				return nil
+			case protowire.BytesType:
+			default:
+				return fmt.Errorf("%w: field 7 has wire type %d", ErrMalformed, f.typ)
+			}
+			if len(f.bytes)%4 != 0 {
+				return fmt.Errorf("%w: embedding length %d", ErrMalformed, len(f.bytes))
+			}
+			for i := 0; i < len(f.bytes); i += 4 {
+				value, _ := protowire.ConsumeFixed32(f.bytes[i:])
+				news.Embedding = append(news.Embedding, math.Float32frombits(value))
+			}
+		case 8:
+			news.PublishDate = string(f.bytes)
+		case 9:
+			news.IsRT = f.varint != 0
+		case 10:
+			news.SourceName = string(f.bytes)
+		case 11:
+			news.URL = string(f.bytes)
+		case 12:
+			news.IsDuplicate = f.varint != 0
+		case 13:
+			news.DuplicateOf = int64(f.varint)
+		case 14:
+			decision, err := unmarshalDecision(f.bytes)
+			if err != nil {
+				return err
+			}
+			news.Decision = decision
+		}
+		return nil
+	})
+	return news, err
+}
+
+func unmarshalDecision(data []byte) (*Decision, error) {
+	decision := &Decision{}
+	err := fields(data, decisionTypes, func(f field) error {
+		switch f.num {
+		case 1:
+			decision.Outcome = string(f.bytes)
+		case 2:
+			decision.Strategy = string(f.bytes)
+		case 3:
+			decision.ConfigVersion = string(f.bytes)
+		case 4:
+			decision.Distance = math.Float64frombits(f.fixed64)
+		case 5:
+			decision.Similarity = math.Float64frombits(f.fixed64)
+		case 6:
+			decision.Threshold = math.Float64frombits(f.fixed64)
+		case 7:
+			decision.ClusterSize = int64(f.varint)
+		case 8:
+			candidate, err := unmarshalCandidate(f.bytes)
+			if err != nil {
+				return err
+			}
+			decision.RunnerUp = candidate
+		}
+		return nil
+	})
+	return decision, err
+}
+
+func unmarshalCandidate(data []byte) (*Candidate, error) {
+	candidate := &Candidate{}
+	err := fields(data, candidateTypes, func(f field) error {
+		switch f.num {
+		case 1:
+			candidate.ClusterID = int64(f.varint)
+		case 2:
+			candidate.NewsCount = int64(f.varint)
+		case 3:
+			candidate.Distance = math.Float64frombits(f.fixed64)
+		case 4:
+			candidate.Score = math.Float64frombits(f.fixed64)
+		case 5:
+			candidate.Threshold = math.Float64frombits(f.fixed64)
+		case 6:
+			candidate.SimilarityThreshold = math.Float64frombits(f.fixed64)
+		case 7:
+			candidate.Vetoed = f.varint != 0
+		case 8:
+			candidate.Matched = f.varint != 0
+		}
+		return nil
+	})
+	return candidate, err
+}
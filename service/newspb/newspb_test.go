@@ -0,0 +1,198 @@
+package newspb
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRoundTrip(t *testing.T) {
+	news := News{
+		ID:          42,
+		ClusterID:   7,
+		Title:       "Заголовок",
+		FullText:    "text",
+		Embedding:   []float32{0.5, -1.25, 3},
+		PublishDate: "2024-01-02T03:04:05Z",
+		IsRT:        true,
+		SourceName:  "source",
+		URL:         "https://example.com/1",
+		Decision: &Decision{
+			Outcome:     "joined",
+			Distance:    0.12,
+			ClusterSize: 3,
+			RunnerUp:    &Candidate{ClusterID: 9, Distance: 0.4, Matched: true},
+		},
+	}
+	data := Marshal(news)
+	decoded, err := Decode(ContentTypeProtobuf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(news, decoded) {
+		t.Fatalf("decoded %+v, want %+v", decoded, news)
+	}
+
+	jsonData, _ := json.Marshal(news)
+	if len(data) >= len(jsonData) {
+		t.Errorf("protobuf %d bytes is not smaller than JSON %d bytes", len(data), len(jsonData))
+	}
+	fromJSON, err := Decode(ContentTypeJSON, jsonData)
+	if err != nil || !reflect.DeepEqual(news, fromJSON) {
+		t.Fatalf("JSON decode: %v %+v", err, fromJSON)
+	}
+}
+
+func TestUnmarshalTruncated(t *testing.T) {
+	data := Marshal(News{ID: 1, Title: "title"})
+	if _, err := Unmarshal(data[:len(data)-2]); err == nil {
+		t.Fatal("expected error for truncated message")
+	}
+}
+
+func TestUnmarshalWrongWireType(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "string as varint", data: protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 1)},
+		{name: "id as bytes", data: protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "1")},
+		{name: "embedding as varint", data: protowire.AppendVarint(protowire.AppendTag(nil, 7, protowire.VarintType), 1)},
+		{name: "decision distance as varint", data: appendMessage(nil, 14, protowire.AppendVarint(protowire.AppendTag(nil, 4, protowire.VarintType), 1))},
+		{name: "runner up id as fixed64", data: appendMessage(nil, 14, appendMessage(nil, 8, protowire.AppendFixed64(protowire.AppendTag(nil, 1, protowire.Fixed64Type), 1)))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Unmarshal(test.data); !errors.Is(err, ErrMalformed) {
+				t.Errorf("err = %v, want ErrMalformed", err)
+			}
+		})
+	}
+	// Неизвестные поля пропускаются при любом типе
+	unknown := protowire.AppendVarint(protowire.AppendTag(nil, 99, protowire.VarintType), 1)
+	if _, err := Unmarshal(unknown); err != nil {
+		t.Errorf("unknown field: err = %v", err)
+	}
+}
+
+// protoField is a field declared in news.proto
+type protoField struct {
+	name     string
+	typ      string
+	repeated bool
+}
+
+// parseProto reads message fields of news.proto, the file has no nested or multi-line declarations
+func parseProto(t *testing.T) map[string]map[protowire.Number]protoField {
+	t.Helper()
+	data, err := os.ReadFile("news.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageRe := regexp.MustCompile(`^message (\w+) \{$`)
+	fieldRe := regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+)( \[packed = true\])?;$`)
+	messages := make(map[string]map[protowire.Number]protoField)
+	var current map[protowire.Number]protoField
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if match := messageRe.FindStringSubmatch(line); match != nil {
+			current = make(map[protowire.Number]protoField)
+			messages[match[1]] = current
+			continue
+		}
+		match := fieldRe.FindStringSubmatch(line)
+		if match == nil || current == nil {
+			continue
+		}
+		num, _ := strconv.Atoi(match[4])
+		current[protowire.Number(num)] = protoField{name: match[3], typ: match[2], repeated: match[1] != ""}
+	}
+	return messages
+}
+
+// TestProtoSchema keeps the hand-written codec in line with news.proto: field numbers,
+// wire types, JSON names and Go types of the structs
+func TestProtoSchema(t *testing.T) {
+	wire := map[string]protowire.Type{
+		"int64":  protowire.VarintType,
+		"bool":   protowire.VarintType,
+		"double": protowire.Fixed64Type,
+		"string": protowire.BytesType,
+	}
+	kinds := map[string]reflect.Kind{
+		"int64":  reflect.Int64,
+		"bool":   reflect.Bool,
+		"double": reflect.Float64,
+		"string": reflect.String,
+	}
+	messages := parseProto(t)
+	tests := []struct {
+		message string
+		types   wireTypes
+		value   any
+	}{
+		{message: "News", types: newsTypes, value: News{}},
+		{message: "Decision", types: decisionTypes, value: Decision{}},
+		{message: "Candidate", types: candidateTypes, value: Candidate{}},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			declared := messages[test.message]
+			structType := reflect.TypeOf(test.value)
+			if len(declared) != structType.NumField() {
+				t.Fatalf("news.proto declares %d fields, struct has %d", len(declared), structType.NumField())
+			}
+			for num, f := range declared {
+				if num < 1 || int(num) > structType.NumField() {
+					t.Errorf("%s = %d is out of struct field order", f.name, num)
+					continue
+				}
+				goField := structType.Field(int(num) - 1)
+				if name := strings.Split(goField.Tag.Get("json"), ",")[0]; name != f.name {
+					t.Errorf("field %d: JSON name %q, news.proto name %q", num, name, f.name)
+				}
+				switch {
+				case f.repeated:
+					// Упакованный repeated float проверяется в fields отдельно
+					if f.typ != "float" || goField.Type != reflect.TypeOf([]float32{}) {
+						t.Errorf("%s: unsupported repeated %s as %s", f.name, f.typ, goField.Type)
+					}
+					if _, ok := test.types[num]; ok {
+						t.Errorf("%s: packed field must not be in wire types", f.name)
+					}
+				case messages[f.typ] != nil:
+					if test.types[num] != protowire.BytesType {
+						t.Errorf("%s: wire type %v, want bytes", f.name, test.types[num])
+					}
+					if goField.Type.Kind() != reflect.Pointer || goField.Type.Elem().Name() != f.typ {
+						t.Errorf("%s: Go type %s, want *%s", f.name, goField.Type, f.typ)
+					}
+				default:
+					want, ok := wire[f.typ]
+					if !ok {
+						t.Errorf("%s: unsupported type %s", f.name, f.typ)
+						continue
+					}
+					if got, ok := test.types[num]; !ok || got != want {
+						t.Errorf("%s: wire type %v, want %v", f.name, got, want)
+					}
+					if goField.Type.Kind() != kinds[f.typ] {
+						t.Errorf("%s: Go type %s, want %s", f.name, goField.Type, f.typ)
+					}
+				}
+			}
+			for num := range test.types {
+				if _, ok := declared[num]; !ok {
+					t.Errorf("wire types have field %d missing in news.proto", num)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package vector
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Return lower case words of text for TFIDF, one letter words are dropped
+func Tokenize(text string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(token)) > 1 {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Return TF-IDF vectors of documents given as lists of tokens.
+// All vectors share the vocabulary of the documents, empty documents get zero vectors.
+func TFIDF(documents [][]string) []*Vector {
+	index := map[string]int{}
+	frequency := []int{}
+	for _, document := range documents {
+		seen := map[string]bool{}
+		for _, token := range document {
+			i, ok := index[token]
+			if !ok {
+				i = len(frequency)
+				index[token] = i
+				frequency = append(frequency, 0)
+			}
+			if !seen[token] {
+				seen[token] = true
+				frequency[i]++
+			}
+		}
+	}
+
+	result := make([]*Vector, len(documents))
+	for d, document := range documents {
+		values := make([]float64, len(frequency))
+		for _, token := range document {
+			values[index[token]]++
+		}
+		for i, count := range values {
+			if count == 0 {
+				continue
+			}
+			// Сглаженный IDF не обнуляет слова, встречающиеся во всех документах
+			idf := math.Log(1 + float64(len(documents))/float64(frequency[i]))
+			values[i] = count / float64(len(document)) * idf
+		}
+		result[d] = &Vector{vector: values}
+	}
+	return result
+}
@@ -197,6 +197,26 @@ func (v *Vector) ToPqString() string {
 	return strVec
 }
 
+// Parse vector from postgres (pgvector) string representation like [1,2,3]
+func FromPqString(str string) (*Vector, error) {
+	str = strings.TrimSpace(str)
+	str = strings.TrimPrefix(str, "[")
+	str = strings.TrimSuffix(str, "]")
+	if str == "" {
+		return &Vector{vector: []float64{}}, nil
+	}
+	parts := strings.Split(str, ",")
+	result := make([]float64, len(parts))
+	for i, part := range parts {
+		val, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = val
+	}
+	return &Vector{vector: result}, nil
+}
+
 func (v *Vector) MinkowskiDistance(other *Vector, p float64) float64 {
 	sum := 0.0
 	if p <= 1 {